@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/FactomProject/fer-api/ferclient"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The ferclient types are copies of the server's, so every field of one has
+// to come through the other unchanged.
+func TestClientTypesRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		from, to interface{}
+	}{
+		// Requests, from the client to the server
+		{"ChangeRequest", new(ferclient.ChangeRequest), new(ChangeResponse)},
+		{"ProposeRequest", new(ferclient.ProposeRequest), new(proposeRequest)},
+		{"VoteRequest", new(ferclient.VoteRequest), new(voteRequest)},
+		{"FinalizeRequest", new(ferclient.FinalizeRequest), new(finalizeRequest)},
+		{"ScheduleRequest", new(ferclient.ScheduleRequest), new(scheduleRequest)},
+		{"EntryFilter", new(ferclient.EntryFilter), new(EntryFilter)},
+		{"DecodeCommitRequest", new(ferclient.DecodeCommitRequest), new(decodeCommitRequest)},
+
+		// Results, from the server to the client
+		{"ComposedEntry", new(addressResponse), new(ferclient.ComposedEntry)},
+		{"Proposal", new(Proposal), new(ferclient.Proposal)},
+		{"PreparedEntry", new(prepareResponse), new(ferclient.PreparedEntry)},
+		{"ScheduledChange", new(ScheduledChange), new(ferclient.ScheduledChange)},
+		{"PriceDecision", new(PriceDecision), new(ferclient.PriceDecision)},
+		{"StoredEntry", new(StoredEntry), new(ferclient.StoredEntry)},
+		{"RateHistory", new(RateHistory), new(ferclient.RateHistory)},
+		{"CommitInfo", new(CommitInfo), new(ferclient.CommitInfo)},
+	} {
+		fill(reflect.ValueOf(c.from).Elem())
+		sent, err := json.Marshal(c.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(sent))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c.to); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		back, err := json.Marshal(c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, got interface{}
+		json.Unmarshal(sent, &want)
+		json.Unmarshal(back, &got)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: sent %s, got back %s", c.name, sent, back)
+		}
+	}
+}
+
+// Sets every field of v to something other than its zero value.
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("1")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fill(key)
+		fill(elem)
+		v.SetMapIndex(key, elem)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				fill(v.Field(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/fer-api/internal/entrybinary"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// An entry commit, as made by composeEntryCommit:
+//
+//	version        1 byte
+//	milliseconds   6 bytes, big endian
+//	entry hash    32 bytes
+//	EC cost        1 byte
+//	EC public key 32 bytes
+//	signature     64 bytes, by the EC key over the 40 bytes before the key
+const commitSize = 136
+
+type CommitInfo struct {
+	Version        byte      `json:"version"`
+	Milliseconds   int64     `json:"milliseconds"`
+	Time           time.Time `json:"time"`
+	EntryHash      string    `json:"entry-hash"`
+	ECCost         byte      `json:"ec-cost"`
+	ECPublicKey    string    `json:"ec-public-key"`
+	ECAddress      string    `json:"ec-address"`
+	Signature      string    `json:"signature"`
+	SignatureValid bool      `json:"signature-valid"`
+	// Set when checked against the reveal
+	RevealEntryHash string `json:"reveal-entry-hash,omitempty"`
+	RevealECCost    byte   `json:"reveal-ec-cost,omitempty"`
+	MatchesReveal   *bool  `json:"matches-reveal,omitempty"`
+}
+
+func decodeCommit(b []byte) (*CommitInfo, error) {
+	if len(b) != commitSize {
+		return nil, errors.New(fmt.Sprintf("Commit is %d bytes, expected %d", len(b), commitSize))
+	}
+	c := new(CommitInfo)
+	c.Version = b[0]
+	c.Milliseconds = int64(binary.BigEndian.Uint64(append([]byte{0, 0}, b[1:7]...)))
+	c.Time = time.Unix(0, c.Milliseconds*1e6).UTC()
+	c.EntryHash = hex.EncodeToString(b[7:39])
+	c.ECCost = b[39]
+
+	var publicKey [ed.PublicKeySize]byte
+	var sig [ed.SignatureSize]byte
+	copy(publicKey[:], b[40:72])
+	copy(sig[:], b[72:])
+	c.ECPublicKey = hex.EncodeToString(publicKey[:])
+	c.ECAddress = ecPublicAddress(&publicKey)
+	c.Signature = hex.EncodeToString(sig[:])
+	c.SignatureValid = ed.Verify(&publicKey, b[:40], &sig)
+	return c, nil
+}
+
+// Checks the commit pays for the entry in the reveal: the same entry hash
+// and the EC cost of its size.
+func (c *CommitInfo) checkReveal(entry []byte) error {
+	e, err := entrybinary.Unmarshal(entry)
+	if err != nil {
+		return err
+	}
+	cost, err := factom.EntryCost(e)
+	if err != nil {
+		return err
+	}
+	c.RevealEntryHash = hex.EncodeToString(entryHash(entry))
+	c.RevealECCost = byte(cost)
+	matches := c.RevealEntryHash == c.EntryHash && c.RevealECCost == c.ECCost
+	c.MatchesReveal = &matches
+	return nil
+}
+
+// Everything about the commit checks out
+func (c *CommitInfo) valid() bool {
+	return c.SignatureValid && (c.MatchesReveal == nil || *c.MatchesReveal)
+}
+
+type decodeCommitRequest struct {
+	// The commit-entry request or its hex message
+	EntryCommit string `json:"entry-commit"`
+	// Optional, the reveal-entry request or the entry hex
+	RevealEntry string `json:"reveal-entry"`
+}
+
+func handleDecodeCommit(params []byte) (interface{}, *factom.JSONError) {
+	req := new(decodeCommitRequest)
+	if err := json.Unmarshal(params, req); err != nil || req.EntryCommit == "" {
+		return nil, newInvalidParamsError()
+	}
+	commit, err := findRequestParam(req.EntryCommit, "message")
+	if err != nil {
+		return nil, newInvalidParamsError()
+	}
+	c, err := decodeCommit(commit)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	if req.RevealEntry != "" {
+		entry, err := findRequestParam(req.RevealEntry, "entry")
+		if err != nil {
+			return nil, newInvalidParamsError()
+		}
+		if err := c.checkReveal(entry); err != nil {
+			return nil, newCustomInternalError(err.Error())
+		}
+	}
+	return c, nil
+}
+
+// "fer-api decode-commit" reads a commit like verify reads an entry.  The
+// reveal is taken from -reveal, or else from the same input when it has one,
+// as a curl file does.
+func runDecodeCommitCommand(args []string) error {
+	flags := flag.NewFlagSet("decode-commit", flag.ExitOnError)
+	reveal := flags.String("reveal", "", "reveal-entry request, entry hex or a file holding one")
+	flags.Parse(args)
+
+	input, err := readCommandInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	commit, err := findRequestParam(string(input), "message")
+	if err != nil {
+		return errors.New("No commit found: give a commit-entry request, a curl file or the commit as hex")
+	}
+	c, err := decodeCommit(commit)
+	if err != nil {
+		return err
+	}
+
+	if *reveal != "" {
+		revealInput, err := readCommandInput(*reveal)
+		if err != nil {
+			return err
+		}
+		entry, err := findRequestParam(string(revealInput), "entry")
+		if err != nil {
+			return errors.New("No entry found in -reveal")
+		}
+		if err := c.checkReveal(entry); err != nil {
+			return err
+		}
+	} else if entry, err := findStructuredParam(string(input), "entry"); err == nil {
+		if err := c.checkReveal(entry); err != nil {
+			return err
+		}
+	}
+
+	printCommit(c)
+	if !c.valid() {
+		return errors.New("The commit does not verify")
+	}
+	fmt.Println("\nOK")
+	return nil
+}
+
+// A file's content, stdin for "" or "-", or else the argument itself
+func readCommandInput(arg string) ([]byte, error) {
+	switch {
+	case arg == "" || arg == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case fileExists(arg):
+		return ioutil.ReadFile(arg)
+	}
+	return []byte(arg), nil
+}
+
+func printCommit(c *CommitInfo) {
+	fmt.Printf("Version:            %d\n", c.Version)
+	fmt.Printf("Timestamp:          %d ms (%s)\n", c.Milliseconds, c.Time.Format(time.RFC3339Nano))
+	fmt.Printf("Entry hash:         %s\n", c.EntryHash)
+	fmt.Printf("EC cost:            %d\n", c.ECCost)
+	fmt.Printf("EC public key:      %s\n", c.ECPublicKey)
+	fmt.Printf("EC address:         %s\n", c.ECAddress)
+	if c.SignatureValid {
+		fmt.Printf("Signature:          valid\n")
+	} else {
+		fmt.Printf("Signature:          FAIL: not a signature by the EC key\n")
+	}
+	if c.MatchesReveal != nil {
+		if *c.MatchesReveal {
+			fmt.Printf("Reveal:             matches, entry hash %s, EC cost %d\n", c.RevealEntryHash, c.RevealECCost)
+		} else {
+			fmt.Printf("Reveal:             FAIL: the reveal has entry hash %s and EC cost %d\n", c.RevealEntryHash, c.RevealECCost)
+		}
+	} else {
+		fmt.Printf("Reveal:             not checked\n")
+	}
+}
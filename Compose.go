@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"strconv"
+	"strings"
+)
+
+// "fer-api compose" makes an FER entry from the keys in the config file and
+// writes the commit and reveal as curl commands to a file, to be sent to
+// factomd by hand.  Values not given as flags are asked for.
+func runComposeCommand(args []string) error {
+	flags := flag.NewFlagSet("compose", flag.ExitOnError)
+	conf := flags.String("config", configFileName, "config file with the signing and payment keys")
+	expiration := flags.String("expiration-height", "", "block height the entry must be in a block by")
+	activation := flags.String("activation-height", "", "block height the new rate takes effect")
+	priority := flags.String("priority", "", "priority of the entry")
+	price := flags.String("price", "", "new price per EC in factoshis")
+	out := flags.String("out", "FERComposeCurls.dat", "file to write the curl commands to")
+	yes := flags.Bool("yes", false, "write the file without asking")
+	flags.Parse(args)
+	configFileName = *conf
+
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return err
+	}
+	if config.ApprovalsRequired > 0 {
+		return errors.New("FER changes require approval, use propose-fer-change")
+	}
+	// Every composed entry is kept, as the server keeps them
+	entryStore, err = OpenEntryStore(config.GetEntryStorePath())
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s; stop the server or compose through its API", err))
+	}
+	defer func() {
+		entryStore.Close()
+		entryStore = nil
+	}()
+
+	prompts := []struct {
+		value  *string
+		prompt string
+		bits   int
+	}{
+		{expiration, "Expiration height: ", 32},
+		{activation, "Activation height: ", 32},
+		{priority, "Priority: ", 32},
+		{price, "New price per EC (factoshis): ", 64},
+	}
+	for _, p := range prompts {
+		if *p.value != "" {
+			continue
+		}
+		v, err := readStdinUint(p.prompt, "Not a number.", p.bits)
+		if err != nil {
+			return err
+		}
+		*p.value = strconv.FormatUint(v, 10)
+	}
+
+	entry, reveal, targetPriceInDollars, ecAddress, err := CreateFEREntryAndReveal(*expiration, *activation, *priority, *price)
+	if err != nil {
+		return err
+	}
+	output := GetCurlOutputForComposition(entry, reveal, targetPriceInDollars, ecAddress, config.GetFactomdServer())
+	fmt.Print(output)
+
+	if !*yes {
+		answer, err := readStdinLine(fmt.Sprintf("Write this to %s? [y/N] ", *out))
+		if err != nil {
+			return errors.New(fmt.Sprintf("No answer, nothing written (-yes skips the question): %s", err))
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing written")
+			return nil
+		}
+	}
+	if _, err := WriteToFile(*out, output); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", *out)
+	return nil
+}
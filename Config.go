@@ -5,28 +5,206 @@ import (
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"reflect"
+	"time"
+	"strings"
+	"encoding/hex"
+	ed "github.com/FactomProject/ed25519"
 	"github.com/FactomProject/goleveldb/leveldb/errors"
 )
 
-
+// The config file read by every request
+var configFileName = "FactomFER.conf"
 
 // Info from config file
-// Everything that you add to this config struct must be added in the corresponding
-// order to the array ConfigFieldNames.  This makes the error messages correct when
-// fields are missing
+// Every required field in this config struct must be added to the array
+// ConfigFieldNames.  Fields not listed there are optional and fall back to a
+// default when left out of the file.
 type Config struct {
 	PaymentPrivateKey   string
 	SigningPrivateKey     string
 	Version string
+	// Hex ed25519 public key of SigningPrivateKey.  A host that only prepares
+	// and finalizes offline signed entries sets this instead of the private key.
+	SigningPublicKey string
+	// Keystore or external command holding the keys instead of the hex fields
+	// above.  See Signer.go.
+	SigningKeyBackend KeyBackend
+	PaymentKeyBackend KeyBackend
+
+	// factomd API host:port used when submitting entries
+	FactomdServer string
+
+	// Hex ed25519 public keys allowed to approve or reject FER proposals
+	Approvers []string
+	// Approvals needed before a proposal is signed.  0 disables proposals and
+	// lets change-price sign directly.
+	ApprovalsRequired int
+	// How long a proposal stays open, as a Go duration ("24h")
+	ProposalLifetime string
+	ProposalFile string
+
+	// readyz fails below this EC balance on the payment address, or when the
+	// leader height hasn't moved for LeaderStallTimeout (default "20m")
+	MinECBalance int64
+	LeaderStallTimeout string
+
+	// leveldb directory keeping every composed entry (default "FEREntries.db")
+	EntryStorePath string
+
+	// Scheduled changes go out once the leader height is within
+	// ScheduleLeadBlocks (default 6) of their activation height
+	ScheduleFile       string
+	ScheduleLeadBlocks int
+
+	// Sets the FER from an FCT/USD price source, see PriceFeed.go
+	PriceFeed PriceFeedConfig
+
+	// Buys entry credits when the payment EC address runs low, see TopUp.go
+	TopUp TopUpConfig
+
+	// URLs sent lifecycle events, see Webhooks.go
+	Webhooks []WebhookConfig
 }
 
+var ConfigFieldNames = []string {"Version"}
+
 func GetConfigFieldName(fieldIndex int) (fieldName string) {
-	var ConfigFieldNames = []string {"PaymentPrivateKey", "SigningPrivateKey", "Version"}
-	if (fieldIndex >= 0 ) && (fieldIndex <= len(ConfigFieldNames)) { return "" }
+	if (fieldIndex < 0 ) || (fieldIndex >= len(ConfigFieldNames)) { return "" }
 	return ConfigFieldNames[fieldIndex]
 }
 
+func (c Config) GetFactomdServer() string {
+	if c.FactomdServer == "" {
+		return "localhost:8088"
+	}
+	return c.FactomdServer
+}
+
+func (c Config) hasSigningKey() bool {
+	return c.SigningPrivateKey != "" || c.SigningKeyBackend.Type != ""
+}
+
+func (c Config) hasPaymentKey() bool {
+	return c.PaymentPrivateKey != "" || c.PaymentKeyBackend.Type != ""
+}
 
+// Signs FEREntry content
+func (c Config) GetSigningSigner() (Signer, error) {
+	if !c.hasSigningKey() {
+		return nil, errors.New("No signing key configured, use prepare-fer-entry and finalize-fer-entry to sign offline")
+	}
+	s, err := newSigner(c.SigningKeyBackend, c.SigningPrivateKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Signing key: %s", err))
+	}
+	return s, nil
+}
+
+// Signs entry commits, paying for them with its entry credits
+func (c Config) GetPaymentSigner() (Signer, error) {
+	s, err := newSigner(c.PaymentKeyBackend, c.PaymentPrivateKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Payment key: %s", err))
+	}
+	return s, nil
+}
+
+// The public EC address (EC...) that pays for entries
+func (c Config) GetPaymentECAddress() (string, error) {
+	signer, err := c.GetPaymentSigner()
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := signer.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	return ecPublicAddress(publicKey), nil
+}
+
+// The public key entries are signed with, from SigningPublicKey or from the
+// signing key itself.
+func (c Config) GetSigningPublicKey() (*[ed.PublicKeySize]byte, error) {
+	var publicKey [ed.PublicKeySize]byte
+	if c.SigningPublicKey != "" {
+		publicBytes, err := hex.DecodeString(c.SigningPublicKey)
+		if err != nil || len(publicBytes) != ed.PublicKeySize {
+			return nil, errors.New("Signing public key isn't parsable")
+		}
+		copy(publicKey[:], publicBytes)
+	}
+	if c.hasSigningKey() {
+		signer, err := c.GetSigningSigner()
+		if err != nil {
+			return nil, err
+		}
+		derived, err := signer.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if c.SigningPublicKey != "" && *derived != publicKey {
+			return nil, errors.New("SigningPublicKey does not match the signing key")
+		}
+		publicKey = *derived
+	}
+	return &publicKey, nil
+}
+
+func (c Config) GetProposalLifetime() time.Duration {
+	d, err := time.ParseDuration(c.ProposalLifetime)
+	if err != nil || d <= 0 {
+		return 24 * time.Hour
+	}
+	return d
+}
+
+func (c Config) GetLeaderStallTimeout() time.Duration {
+	d, err := time.ParseDuration(c.LeaderStallTimeout)
+	if err != nil || d <= 0 {
+		return 20 * time.Minute
+	}
+	return d
+}
+
+func (c Config) GetEntryStorePath() string {
+	if c.EntryStorePath == "" {
+		return "FEREntries.db"
+	}
+	return c.EntryStorePath
+}
+
+func (c Config) GetScheduleFile() string {
+	if c.ScheduleFile == "" {
+		return "FERSchedule.json"
+	}
+	return c.ScheduleFile
+}
+
+func (c Config) GetScheduleLeadBlocks() int {
+	if c.ScheduleLeadBlocks < 2 {
+		return 6
+	}
+	return c.ScheduleLeadBlocks
+}
+
+func (c Config) GetProposalFile() string {
+	if c.ProposalFile == "" {
+		return "FERProposals.json"
+	}
+	return c.ProposalFile
+}
+
+
+
+// TOML parse errors quote the text they choked on, which may be a private key.
+// Only the location is kept.
+func tomlErrorText(err error) string {
+	msg := err.Error()
+	if i := strings.Index(msg, "):"); strings.HasPrefix(msg, "Near line") && i > 0 {
+		return msg[:i+1]
+	}
+	return msg
+}
 
 // Reads info from config file.
 func readConfigFile(configFileName string) (Config, error) {
@@ -34,24 +212,56 @@ func readConfigFile(configFileName string) (Config, error) {
 
 	_, err := os.Stat(configFileName)
 	if err != nil {
-
-		fmt.Println("sub-error", err)
-
 		return config, errors.New(fmt.Sprintf("Config file is missing: %s", configFileName))
 	}
 
 	if _, err := toml.DecodeFile(configFileName, &config); err != nil {
-		return config, errors.New(fmt.Sprintf("Error reading your file: %s  Error: %s", configFileName, err))
+		return config, errors.New(fmt.Sprintf("Error reading your file: %s  Error: %s", configFileName, tomlErrorText(err)))
 	}
 
 	fieldsMissed := false;
 	fields := reflect.ValueOf(config)
-	values := make([]interface{}, fields.NumField())
-	for i := 0; i < fields.NumField(); i++ {
-		values[i] = fields.Field(i).Interface()
-		if (values[i] == "" ) {
+	for i := range ConfigFieldNames {
+		if (fields.FieldByName(GetConfigFieldName(i)).Interface() == "" ) {
 			fieldsMissed = true;
-			fmt.Println("Error Couldn't read value for config field ", GetConfigFieldName(i))
+			logger.Error("Couldn't read value for config field", "field", GetConfigFieldName(i), "file", configFileName)
+		}
+	}
+
+	if !config.hasPaymentKey() {
+		fieldsMissed = true;
+		logger.Error("Couldn't read value for config field", "field", "PaymentPrivateKey or PaymentKeyBackend", "file", configFileName)
+	}
+	if !config.hasSigningKey() && (config.SigningPublicKey == "") {
+		fieldsMissed = true;
+		logger.Error("Couldn't read value for config field", "field", "SigningPrivateKey, SigningPublicKey or SigningKeyBackend", "file", configFileName)
+	}
+
+	if config.ScheduleLeadBlocks > maxExpirationAhead+1 {
+		return config, errors.New(fmt.Sprintf("ScheduleLeadBlocks is %d, at most %d: factomd drops entries expiring more than %d blocks ahead", config.ScheduleLeadBlocks, maxExpirationAhead+1, maxExpirationAhead))
+	}
+
+	if (config.ApprovalsRequired > len(config.Approvers)) {
+		return config, errors.New(fmt.Sprintf("ApprovalsRequired is %d but only %d Approvers are configured", config.ApprovalsRequired, len(config.Approvers)))
+	}
+
+	names := make(map[string]bool)
+	for _, hook := range config.Webhooks {
+		if hook.Name == "" || hook.URL == "" || hook.Secret == "" || names[hook.Name] {
+			return config, errors.New("Every webhook needs a unique Name, a URL and a Secret")
+		}
+		names[hook.Name] = true
+		for _, e := range hook.Events {
+			if !knownEventType(e) {
+				return config, errors.New(fmt.Sprintf("Webhook %s wants unknown event %s", hook.Name, e))
+			}
+		}
+	}
+
+	// Entries are composed with this version, so it has to have a schema
+	if config.Version != "" {
+		if _, err := ferSchema(config.Version); err != nil {
+			return config, errors.New(fmt.Sprintf("Config Version: %s", err))
 		}
 	}
 
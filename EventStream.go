@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb"
+	"github.com/FactomProject/goleveldb/leveldb/util"
+	"github.com/FactomProject/web"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET /events streams the event log (see Events.go) as Server-Sent Events:
+//
+//	id: <event id>
+//	event: <type>
+//	data: <the Event as JSON>
+//
+// Besides the lifecycle events, the chain watcher adds chain-entry for every
+// new entry on the FER chain and rate-changed when factom.GetRate changes.
+// A client resumes with the Last-Event-ID header (or ?last-event-id=, as the
+// browser EventSource can't set headers on its first request) and gets every
+// event after that id before the live ones.  ?types=a,b limits the types.
+const (
+	EventChainEntry  = "chain-entry"
+	EventRateChanged = "rate-changed"
+)
+
+// The most events sent from the log in one go before checking for new ones
+const eventBatch = 500
+
+// Closed when the server shuts down, so open streams end and don't hold up
+// the shutdown.
+var stopStreams = make(chan struct{})
+
+// Every open stream gets a channel poked on each new event.
+var eventSubscribers = struct {
+	sync.Mutex
+	m map[chan struct{}]bool
+}{m: make(map[chan struct{}]bool)}
+
+func subscribeEvents() chan struct{} {
+	c := make(chan struct{}, 1)
+	eventSubscribers.Lock()
+	eventSubscribers.m[c] = true
+	eventSubscribers.Unlock()
+	return c
+}
+
+func unsubscribeEvents(c chan struct{}) {
+	eventSubscribers.Lock()
+	delete(eventSubscribers.m, c)
+	eventSubscribers.Unlock()
+}
+
+func notifyEventSubscribers() {
+	eventSubscribers.Lock()
+	defer eventSubscribers.Unlock()
+	for c := range eventSubscribers.m {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Events with ids above after, oldest first
+func (s *EntryStore) eventsAfter(after uint64, limit int) ([]*Event, error) {
+	events := make([]*Event, 0)
+	iter := s.db.NewIterator(&util.Range{Start: eventKey(after + 1), Limit: []byte("v;")}, nil)
+	defer iter.Release()
+	for iter.Next() && len(events) < limit {
+		event := new(Event)
+		if err := json.Unmarshal(iter.Value(), event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, iter.Error()
+}
+
+func handleEvents(ctx *web.Context) {
+	if entryStore == nil {
+		writeJSON(ctx, http.StatusServiceUnavailable, map[string]string{"error": "no entry store"})
+		return
+	}
+	flusher, ok := ctx.ResponseWriter.(http.Flusher)
+	if !ok {
+		writeJSON(ctx, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return
+	}
+
+	lastID := ctx.Request.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = ctx.Request.URL.Query().Get("last-event-id")
+	}
+	var after uint64
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			writeJSON(ctx, http.StatusBadRequest, map[string]string{"error": "bad Last-Event-ID"})
+			return
+		}
+		after = id
+	} else {
+		// Only new events for a client that isn't resuming
+		last, err := entryStore.lastEventID()
+		if err != nil {
+			writeJSON(ctx, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		after = last
+	}
+	types := make(map[string]bool)
+	if t := ctx.Request.URL.Query().Get("types"); t != "" {
+		for _, name := range strings.Split(t, ",") {
+			types[strings.TrimSpace(name)] = true
+		}
+	}
+
+	notify := subscribeEvents()
+	defer unsubscribeEvents(notify)
+
+	// A stream outlives the server's write timeout
+	http.NewResponseController(ctx.ResponseWriter).SetWriteDeadline(time.Time{})
+	ctx.SetHeader("Content-Type", "text/event-stream", true)
+	ctx.SetHeader("Cache-Control", "no-cache", true)
+	ctx.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		events, err := entryStore.eventsAfter(after, eventBatch)
+		if err != nil {
+			logger.Warn("Could not read events for a stream", "error", err)
+			return
+		}
+		for _, event := range events {
+			after = event.ID
+			if len(types) > 0 && !types[event.Type] {
+				continue
+			}
+			data, _ := json.Marshal(event)
+			if _, err := fmt.Fprintf(ctx.ResponseWriter, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		if len(events) > 0 {
+			flusher.Flush()
+		}
+		if len(events) == eventBatch {
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(ctx.ResponseWriter, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Request.Context().Done():
+			return
+		case <-stopStreams:
+			return
+		}
+	}
+}
+
+// Watches factomd for new FER chain entries and rate changes.  What it has
+// seen is kept in the entry store, so a restart picks up where it left off.
+func runChainWatcher(interval time.Duration) {
+	for {
+		if entryStore != nil {
+			if err := entryStore.watchChain(); err != nil {
+				logger.Warn("Chain watcher failed", "component", "chain-watcher", "error", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+var (
+	watchedHeadKey = []byte("k:fer-chain-head")
+	// <entry block keymr>:<entries of it published>, while a block is part done
+	watchedEntryKey = []byte("k:fer-chain-entry")
+	watchedRateKey  = []byte("k:rate")
+)
+
+func (s *EntryStore) watchChain() error {
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return err
+	}
+	factom.SetFactomdServer(config.GetFactomdServer())
+
+	start := time.Now()
+	rate, err := factom.GetRate()
+	observeFactomd("entry-credit-rate", start)
+	if err != nil {
+		return err
+	}
+	rateValue := []byte(strconv.FormatUint(rate, 10))
+	if last, err := s.db.Get(watchedRateKey, nil); err == nil {
+		if old, _ := strconv.ParseUint(string(last), 10, 64); old != rate {
+			err := s.publishWith(EventRateChanged, "", map[string]uint64{"old-rate": old, "rate": rate}, func(batch *leveldb.Batch) {
+				batch.Put(watchedRateKey, rateValue)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	} else if err == leveldb.ErrNotFound {
+		if err := s.db.Put(watchedRateKey, rateValue, nil); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	start = time.Now()
+	head, err := factom.GetChainHead(FERChainID)
+	observeFactomd("chain-head", start)
+	if err != nil {
+		return err
+	}
+	seen, err := s.db.Get(watchedHeadKey, nil)
+	if err == leveldb.ErrNotFound {
+		// First run: start from the current head rather than replay the chain
+		return s.db.Put(watchedHeadKey, []byte(head), nil)
+	}
+	if err != nil {
+		return err
+	}
+	if string(seen) == head {
+		return nil
+	}
+
+	// Walk back all the way to the last head seen, however far behind the
+	// watcher is, then publish oldest first
+	blocks := make([]*factom.EBlock, 0)
+	keyMRs := make([]string, 0)
+	for keyMR := head; keyMR != string(seen) && keyMR != factom.ZeroHash; {
+		start = time.Now()
+		eb, err := factom.GetEBlock(keyMR)
+		observeFactomd("entry-block", start)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, eb)
+		keyMRs = append(keyMRs, keyMR)
+		keyMR = eb.Header.PrevKeyMR
+	}
+
+	// Entries of a block already published, from a pass cut off part way
+	done := 0
+	if cursor, err := s.db.Get(watchedEntryKey, nil); err == nil {
+		if parts := strings.SplitN(string(cursor), ":", 2); len(parts) == 2 && parts[0] == keyMRs[len(keyMRs)-1] {
+			done, _ = strconv.Atoi(parts[1])
+		}
+	} else if err != leveldb.ErrNotFound {
+		return err
+	}
+
+	// The cursor moves with each event, in the same batch, so an entry is
+	// published once however a pass ends
+	for i := len(blocks) - 1; i >= 0; i-- {
+		eb, keyMR := blocks[i], keyMRs[i]
+		for n, ebEntry := range eb.EntryList {
+			if n < done {
+				continue
+			}
+			start = time.Now()
+			e, err := factom.GetEntry(ebEntry.EntryHash)
+			observeFactomd("entry", start)
+			if err != nil {
+				return err
+			}
+			data := map[string]interface{}{"dbheight": eb.Header.DBHeight, "content": string(e.Content)}
+			if len(e.ExtIDs) > 0 {
+				data["signature"] = hex.EncodeToString(e.ExtIDs[0])
+			}
+			if _, err := decodeFEREntry(e.Content); err != nil {
+				data["schema-error"] = err.Error()
+			}
+			cursor := []byte(fmt.Sprintf("%s:%d", keyMR, n+1))
+			err = s.publishWith(EventChainEntry, ebEntry.EntryHash, data, func(batch *leveldb.Batch) {
+				batch.Put(watchedEntryKey, cursor)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		done = 0
+		batch := new(leveldb.Batch)
+		batch.Put(watchedHeadKey, []byte(keyMR))
+		batch.Delete(watchedEntryKey)
+		if err := s.db.Write(batch, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/goleveldb/leveldb"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"github.com/FactomProject/goleveldb/leveldb/opt"
+	"github.com/FactomProject/goleveldb/leveldb/util"
+	"sync"
+	"time"
+)
+
+// Lifecycle events of FER entries, kept in the entry store under
+//   v:<seq>                 the Event as JSON
+//   o:<seq>:<webhook name>  a webhook delivery still to be made
+// seq is a big endian uint64, so events iterate in the order they happened.
+// An event and its deliveries are written in one batch, so neither is lost
+// across a restart.
+
+const (
+	EventComposed       = "composed"
+	EventCommitAccepted = "commit-accepted"
+	EventRevealAccepted = "reveal-accepted"
+	EventAcknowledged   = "acknowledged"
+	EventConfirmed      = "dblock-confirmed"
+	EventActivated      = "activated"
+	EventExpired        = "expired"
+	EventPolicyRejected = "policy-rejected"
+)
+
+type Event struct {
+	ID        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	EntryHash string      `json:"entry-hash,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Why a change was refused, for policy-rejected events
+type PolicyRejection struct {
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// The last event id handed out.  Loaded from the store when it is opened.
+var eventSeq struct {
+	sync.Mutex
+	last   uint64
+	loaded bool
+}
+
+func eventKey(seq uint64) []byte {
+	key := []byte("v:")
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	return append(key, b[:]...)
+}
+
+func outboxKey(seq uint64, webhook string) []byte {
+	return append(append([]byte("o:"), eventKey(seq)[2:]...), []byte(":"+webhook)...)
+}
+
+func (s *EntryStore) lastEventID() (uint64, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte("v:")), nil)
+	defer iter.Release()
+	last := uint64(0)
+	if iter.Last() {
+		last = binary.BigEndian.Uint64(iter.Key()[2:])
+	}
+	return last, iter.Error()
+}
+
+// publishEvent records an event and queues it for every webhook that wants
+// it.  Failing to record an event is logged but never fails the caller.
+func publishEvent(eventType string, entryHash string, data interface{}) {
+	if entryStore == nil {
+		return
+	}
+	if err := entryStore.publish(eventType, entryHash, data); err != nil {
+		logger.Error("Could not record event", "event", eventType, "entry_hash", entryHash, "error", err)
+	}
+}
+
+func publishPolicyRejection(source string, reason string) {
+	publishEvent(EventPolicyRejected, "", PolicyRejection{Source: source, Reason: reason})
+}
+
+func (s *EntryStore) publish(eventType string, entryHash string, data interface{}) error {
+	return s.publishWith(eventType, entryHash, data, nil)
+}
+
+// publishWith also writes whatever more adds to the batch, e.g. how far the
+// chain watcher got, so the two can't disagree after a crash.
+func (s *EntryStore) publishWith(eventType string, entryHash string, data interface{}, more func(batch *leveldb.Batch)) error {
+	eventSeq.Lock()
+	defer eventSeq.Unlock()
+
+	if !eventSeq.loaded {
+		last, err := s.lastEventID()
+		if err != nil {
+			return err
+		}
+		eventSeq.last = last
+		eventSeq.loaded = true
+	}
+
+	event := &Event{ID: eventSeq.last + 1, Type: eventType, Time: time.Now().UTC(), EntryHash: entryHash, Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(eventKey(event.ID), body)
+
+	if config, err := readConfigFile(configFileName); err == nil {
+		for _, hook := range config.Webhooks {
+			if hook.wants(eventType) {
+				d := &webhookDelivery{EventID: event.ID, Webhook: hook.Name}
+				batch.Put(outboxKey(event.ID, hook.Name), d.marshal())
+			}
+		}
+	}
+	if more != nil {
+		more(batch)
+	}
+	if err := s.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+	eventSeq.last = event.ID
+	notifyEventSubscribers()
+	return nil
+}
+
+func (s *EntryStore) getEvent(id uint64) (*Event, error) {
+	body, err := s.db.Get(eventKey(id), nil)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("No event %d: %s", id, err))
+	}
+	event := new(Event)
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"github.com/FactomProject/factom"
 	"github.com/FactomProject/goleveldb/leveldb/errors"
 	"strconv"
+	"time"
 )
 
 
@@ -20,97 +22,145 @@ type FEREntry struct {
 	Priority               uint32 `json:"priority"`
 	TargetPrice            uint64 `json:"target_price"`
 	Version                string `json:"version"`
+	// Version 2.0 metadata, left out of the content when empty so 1.0
+	// content is unchanged
+	ReasonCode  string `json:"reason_code,omitempty"`
+	Proposer    string `json:"proposer,omitempty"`
+	ProposalRef string `json:"proposal_ref,omitempty"`
 }
 
+// The optional FEREntry fields of version 2.0
+type FERMetadata struct {
+	ReasonCode  string `json:"reason-code,omitempty"`
+	Proposer    string `json:"proposer,omitempty"`
+	ProposalRef string `json:"proposal-ref,omitempty"`
+}
+
+
+// The FER chain: echo -n "This chain contains messages which coordinate the FCT to EC conversion rate amongst factomd nodes." | factom-cli addchain -e "FCT EC Conversion Rate Chain" -e "1950454129" EC2DKSYyRcNWf7RS963VFYgMExoHRYLHVeCfQ9PGPmNzwrcmgm2r
+const FERChainID = "111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf03"
+
+// Creating an entry is split in three steps so the signing can happen on a
+// machine that never sees the network:
+//   NewFEREntry       - the content that gets signed
+//   ed.Sign           - done here or by "fer-api sign" offline
+//   ComposeFEREntry   - the factom entry with the signature, commit and reveal
+// CreateFEREntryAndReveal does all three with the keys in the config file.
 
 //ExpirationHeight string, ActivationHeight string, Priority string, TargetPrice string
 func CreateFEREntryAndReveal(ExpirationHeight string, ActivationHeight string, Priority string, TargetPrice string) (Entry string, Reveal string, targetPriceInDollars float64, newECAddress string, err error) {
+	return CreateFEREntryAndRevealAt(ExpirationHeight, ActivationHeight, Priority, TargetPrice, FERMetadata{}, time.Time{})
+}
+
+// CreateFEREntryAndRevealAt adds the metadata to the FEREntry and stamps the
+// commit with commitTime instead of the current time when it isn't zero, so
+// the same inputs give the same commit.
+func CreateFEREntryAndRevealAt(ExpirationHeight string, ActivationHeight string, Priority string, TargetPrice string, meta FERMetadata, commitTime time.Time) (Entry string, Reveal string, targetPriceInDollars float64, newECAddress string, err error) {
 
 	// Read the config file
-	config, err := readConfigFile("FactomFER.conf")
+	config, err := readConfigFile(configFileName)
 	if ( err != nil ) {
 		errorMessage := errors.New(" Could not find config file FactomFER.conf.\n A sample config file is below, create it if you wish:\n   PaymentPrivateKey = \"0000000000000000000000000000000000000000000000000000000000000000\"\n   SigningPrivateKey = \"0000000000000000000000000000000000000000000000000000000000000000\"\n   Version = \"1.0\"")
 		return "", "", 0.0, "", errorMessage
 	}
 
 	// Make an Fer Entry to send along
-	theFEREntry := new(FEREntry)
-	theFEREntry.Version = config.Version
-
-	// Create and format the payment private key
-	var paymentPrivateKey [32]byte
-	paymentBytes, err := hex.DecodeString(config.PaymentPrivateKey)
+	theFEREntry, err := NewFEREntry(config.Version, ExpirationHeight, ActivationHeight, Priority, TargetPrice, meta)
+	if err != nil {
+		return "", "", 0.0, "", err
+	}
+	entryJson, err := json.Marshal(theFEREntry)
+	if err != nil {
+		return "", "", 0.0, "", errors.New("Could not marshal the data into an FEREntry")
+	}
 
+	// Create the factom entry with the signing private key
+	signer, err := config.GetSigningSigner()
 	if (err != nil) {
-		return "", "", 0.0, "", errors.New("Payment private key isn't parsable")
+		return "", "", 0.0, "", err
 	}
-	copy(paymentPrivateKey[:], paymentBytes)
-	//paymentPublicKey := new([32]byte)
-	//paymentPublicKey = ed.GetPublicKey(&paymentPrivateKey)
-
-	// Create and format the signing private key
-	var signingPrivateKey [64]byte
-	signingBytes, err := hex.DecodeString(config.SigningPrivateKey)
+	signingSignature, err := signer.Sign(entryJson)
 	if (err != nil) {
-		return "", "", 0.0, "",errors.New("Signing private key isn't parsable")
+		return "", "", 0.0, "", err
 	}
-	copy(signingPrivateKey[:], signingBytes[:])
-	_ = ed.GetPublicKey(&signingPrivateKey)  // Needed to format the public half of the key set
+
+	return ComposeFEREntryAt(config, entryJson, signingSignature[:], commitTime)
+}
+
+// NewFEREntry parses the request values into the FEREntry that gets signed,
+// checked against the schema of version.
+func NewFEREntry(version string, ExpirationHeight string, ActivationHeight string, Priority string, TargetPrice string, meta FERMetadata) (*FEREntry, error) {
+	theFEREntry := new(FEREntry)
+	theFEREntry.Version = version
+	theFEREntry.ReasonCode = meta.ReasonCode
+	theFEREntry.Proposer = meta.Proposer
+	theFEREntry.ProposalRef = meta.ProposalRef
 
 	uExpirationHeight, err := strconv.ParseUint(ExpirationHeight, 10, 32)
 	if err != nil {
-		fmt.Println(err)
+		return nil, errors.New(fmt.Sprintf("Invalid expiration height: %s", ExpirationHeight))
 	}
-	uintValue := uExpirationHeight
-	theFEREntry.ExpirationHeight = uint32(uintValue)
+	theFEREntry.ExpirationHeight = uint32(uExpirationHeight)
 
 	uActivationHeight, err := strconv.ParseUint(ActivationHeight, 10, 32)
 	if err != nil {
-		fmt.Println(err)
+		return nil, errors.New(fmt.Sprintf("Invalid activation height: %s", ActivationHeight))
 	}
-	uintValue = uActivationHeight
-	theFEREntry.TargetActivationHeight = uint32(uintValue)
+	theFEREntry.TargetActivationHeight = uint32(uActivationHeight)
 
 	uPriority, err := strconv.ParseUint(Priority, 10, 32)
 	if err != nil {
-		fmt.Println(err)
+		return nil, errors.New(fmt.Sprintf("Invalid priority: %s", Priority))
 	}
-	uintValue = uPriority
-	theFEREntry.Priority = uint32(uintValue)
+	theFEREntry.Priority = uint32(uPriority)
 
 	uTargetPrice, err := strconv.ParseUint(TargetPrice, 10, 64)
 	if err != nil {
-		fmt.Println(err)
+		return nil, errors.New(fmt.Sprintf("Invalid target price: %s", TargetPrice))
 	}
-	uintValue = uTargetPrice
-	theFEREntry.TargetPrice = uintValue
+	theFEREntry.TargetPrice = uTargetPrice
 
-	entryJson, err := json.Marshal(theFEREntry)
+	if err := theFEREntry.validate(); err != nil {
+		return nil, err
+	}
+	return theFEREntry, nil
+}
+
+// ComposeFEREntry builds the factom entry from the signed FEREntry content and
+// makes the commit (paid by the configured EC key) and the reveal.
+func ComposeFEREntry(config Config, entryJson []byte, signature []byte) (Entry string, Reveal string, targetPriceInDollars float64, newECAddress string, err error) {
+	return ComposeFEREntryAt(config, entryJson, signature, time.Time{})
+}
 
+// ComposeFEREntryAt is ComposeFEREntry with the commit stamped with
+// commitTime, or the current time when it is zero.
+func ComposeFEREntryAt(config Config, entryJson []byte, signature []byte, commitTime time.Time) (Entry string, Reveal string, targetPriceInDollars float64, newECAddress string, err error) {
+	theFEREntry, err := decodeFEREntry(entryJson)
 	if err != nil {
-		return "", "", 0.0, "", errors.New("Could not marshal the data into an FEREntry")
+		return "", "", 0.0, "", err
 	}
 
-	// Create the factom entry with the signing private key
-	signingSignature := ed.Sign(&signingPrivateKey, entryJson)
-
+	paymentSigner, err := config.GetPaymentSigner()
+	if (err != nil) {
+		return "", "", 0.0, "", err
+	}
 
 	// Make a new factom entry and populate it
 	e := new(factom.Entry)
-	e.ChainID = "111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf03"
-	//chain name: echo -n "This chain contains messages which coordinate the FCT to EC conversion rate amongst factomd nodes." | factom-cli addchain -e "FCT EC Conversion Rate Chain" -e "1950454129" EC2DKSYyRcNWf7RS963VFYgMExoHRYLHVeCfQ9PGPmNzwrcmgm2r
-	e.ExtIDs = append(e.ExtIDs, signingSignature[:])
+	e.ChainID = FERChainID
+	e.ExtIDs = append(e.ExtIDs, signature)
 	e.Content = entryJson
 
-   
-	a, err := factom.MakeECAddress(paymentPrivateKey[0:32])
+	paymentPublicKey, err := paymentSigner.PublicKey()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return "", "", 0.0, "", err
 	}
+
 	// Create the compose and the reveal
-	//entryCommitJson, err := factom.ComposeEntryCommit(paymentPublicKey, signingSignature, e)
-	entryCommitJson, err := factom.ComposeEntryCommit(e, a )
+	if commitTime.IsZero() {
+		commitTime = time.Now()
+	}
+	entryCommitJson, err := composeEntryCommit(e, paymentSigner, commitTime)
 	if err != nil { return "", "", 0.0, "", err }
 	revealJson, err := factom.ComposeEntryReveal(e)
 	if err != nil { return "", "", 0.0, "", err }
@@ -122,18 +172,136 @@ func CreateFEREntryAndReveal(ExpirationHeight string, ActivationHeight string, P
 		return "", "", 0.0, "", errors.New("Trying to set targetPrice to 0!")
 	}
 	commitResp, err := factom.EncodeJSONString(entryCommitJson)
+	if err != nil { return "", "", 0.0, "", err }
 	revealResp, err := factom.EncodeJSONString(revealJson)
-	return commitResp, revealResp, impliedFctPrice, a.PubString(), nil
+	if err != nil { return "", "", 0.0, "", err }
+
+	if err := recordComposedEntry(theFEREntry, entryJson, signature, commitResp, revealResp, ecPublicAddress(paymentPublicKey)); err != nil {
+		return "", "", 0.0, "", errors.New(fmt.Sprintf("Could not store the composed entry: %s", err))
+	}
+	metricLastSignedPrice.set(float64(theFEREntry.TargetPrice))
+	return commitResp, revealResp, impliedFctPrice, ecPublicAddress(paymentPublicKey), nil
 }
 
+// composeEntryCommit matches factom.ComposeEntryCommit, except the EC
+// signature comes from a Signer so the payment key never has to be loaded here,
+// and the timestamp is commitTime rather than always the current time.
+func composeEntryCommit(e *factom.Entry, ec Signer, commitTime time.Time) (*factom.JSON2Request, error) {
+	buf := new(bytes.Buffer)
+
+	// 1 byte version
+	buf.Write([]byte{0})
+
+	// 6 byte milliTimestamp (truncated unix time)
+	milliTime := new(bytes.Buffer)
+	binary.Write(milliTime, binary.BigEndian, commitTime.UnixNano()/1e6)
+	buf.Write(milliTime.Bytes()[2:])
+
+	// 32 byte Entry Hash
+	buf.Write(e.Hash())
+
+	// 1 byte number of entry credits to pay
+	if c, err := factom.EntryCost(e); err != nil {
+		return nil, err
+	} else {
+		buf.WriteByte(byte(c))
+	}
+
+	// 32 byte Entry Credit Address Public Key + 64 byte Signature
+	pub, err := ec.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ec.Sign(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(pub[:])
+	buf.Write(sig[:])
+
+	params := struct {
+		Message string `json:"message"`
+	}{hex.EncodeToString(buf.Bytes())}
+	return factom.NewJSON2Request("commit-entry", factom.APICounter(), params), nil
+}
+
+// The EC... address string for an EC public key
+func ecPublicAddress(publicKey *[ed.PublicKeySize]byte) string {
+	a := factom.NewECAddress()
+	*a.Pub = *publicKey
+	return a.PubString()
+}
+
+// The signing key is kept as hex, either the 32 byte seed or the full 64 byte key.
+func signingKeyFromHex(key string) (*[ed.PrivateKeySize]byte, error) {
+	var signingPrivateKey [ed.PrivateKeySize]byte
+	signingBytes, err := hex.DecodeString(key)
+	if (err != nil) || (len(signingBytes) < 32) {
+		return nil, errors.New("Signing private key isn't parsable")
+	}
+	copy(signingPrivateKey[:], signingBytes[:])
+	_ = ed.GetPublicKey(&signingPrivateKey)  // Needed to format the public half of the key set
+	return &signingPrivateKey, nil
+}
+
+
+
+// SubmitFEREntry sends a commit and reveal pair made by CreateFEREntryAndReveal
+// to factomd, commit first.  The txid and entry hash come from factomd's replies.
+func SubmitFEREntry(factomdServer string, entryCommitJson string, revealJson string) (txID string, entryHash string, err error) {
+	factom.SetFactomdServer(factomdServer)
+
+	type commitResponse struct {
+		TxID string `json:"txid"`
+	}
+	type revealResponse struct {
+		EntryHash string `json:"entryhash"`
+	}
+
+	commit := new(commitResponse)
+	if err := sendFactomdJSON(entryCommitJson, commit); err != nil {
+		err = errors.New(fmt.Sprintf("Commit failed: %s", err))
+		recordSubmittedEntry(revealJson, "", err)
+		return "", "", err
+	}
+	publishEvent(EventCommitAccepted, entryHashFromReveal(revealJson), map[string]string{"txid": commit.TxID})
+	reveal := new(revealResponse)
+	if err := sendFactomdJSON(revealJson, reveal); err != nil {
+		err = errors.New(fmt.Sprintf("Reveal failed: %s", err))
+		recordSubmittedEntry(revealJson, commit.TxID, err)
+		return commit.TxID, "", err
+	}
+	recordSubmittedEntry(revealJson, commit.TxID, nil)
+	trackSubmittedFEREntry(revealJson)
+	publishEvent(EventRevealAccepted, entryHashFromReveal(revealJson), map[string]string{"txid": commit.TxID})
+	return commit.TxID, reveal.EntryHash, nil
+}
+
+func sendFactomdJSON(request string, result interface{}) error {
+	req, err := factom.ParseJSON2Request(request)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	resp, err := factom.SendFactomdRequest(req)
+	observeFactomd(req.Method, start)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	return json.Unmarshal(resp.JSONResult(), result)
+}
 
 
-func GetCurlOutputForComposition(entryCommitJson string, revealJson string, targetPriceInDollars float64, ECAddress string) (output string){
+// The curl commands send the commit and reveal to factomdServer (host:port).
+func GetCurlOutputForComposition(entryCommitJson string, revealJson string, targetPriceInDollars float64, ECAddress string, factomdServer string) (output string){
 
 	var buffer bytes.Buffer
 
-	entry := fmt.Sprintf("    curl -i -X POST -H 'Content-Type: application/json' -d '%s' localhost:8088/v2\n", string(entryCommitJson))
-	reveal := fmt.Sprintf("    curl -i -X POST -H 'Content-Type: application/json' -d '%s' localhost:8088/v2\n", string(revealJson))
+	entry := fmt.Sprintf("    curl -i -X POST -H 'Content-Type: application/json' -d '%s' %s/v2\n", string(entryCommitJson), factomdServer)
+	reveal := fmt.Sprintf("    curl -i -X POST -H 'Content-Type: application/json' -d '%s' %s/v2\n", string(revealJson), factomdServer)
 	pricePerDollar := fmt.Sprintf("$%.2f", targetPriceInDollars)
 
 	// Make the output file and print to the screen
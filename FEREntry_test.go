@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/FactomProject/fer-api/fertest"
+	"testing"
+	"time"
+)
+
+const (
+	zeroKey          = "0000000000000000000000000000000000000000000000000000000000000000"
+	zeroKeyPublicKey = "3b6a27bcceb6a42d62a3a8d02a6f0d73653215771de243a63ac048a18b59da29"
+	zeroKeyECAddress = "EC2DKSYyRcNWf7RS963VFYgMExoHRYLHVeCfQ9PGPmNzwrcmgm2r"
+)
+
+// An entry composed and submitted by fer-api changes the rate in factomd at
+// its activation height.
+func TestSubmitFEREntryChangesRate(t *testing.T) {
+	f, err := fertest.NewFactomd(zeroKeyPublicKey, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.SetECBalance(zeroKeyECAddress, 100)
+	config := Config{PaymentPrivateKey: zeroKey, SigningPrivateKey: zeroKey, Version: "1.0", FactomdServer: f.Addr()}
+
+	fer, err := NewFEREntry(config.Version, "2", "3", "1", "4000", FERMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(fer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := config.GetSigningSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := signer.Sign(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, reveal, _, ecAddress, err := ComposeFEREntryAt(config, content, signature[:], time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ecAddress != zeroKeyECAddress {
+		t.Fatalf("EC address is %s, want %s", ecAddress, zeroKeyECAddress)
+	}
+	if _, _, err := SubmitFEREntry(config.GetFactomdServer(), commit, reveal); err != nil {
+		t.Fatal(err)
+	}
+
+	f.AdvanceTo(4)
+	for _, c := range []struct {
+		height int64
+		rate   uint64
+	}{{2, 1000}, {3, 4000}, {4, 4000}} {
+		if rate, ok := f.RateAt(c.height); !ok || rate != c.rate {
+			t.Errorf("Rate at %d is %d, want %d", c.height, rate, c.rate)
+		}
+	}
+}
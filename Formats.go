@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/fer-api/internal/entrybinary"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"strings"
+	"time"
+)
+
+// A composed entry can be handed over in several forms, picked with the
+// "format" param of change-price and finalize-fer-entry:
+//
+//	jsonrpc     the commit-entry and reveal-entry JSON-RPC requests (default)
+//	curl        the curl commands, as written by "fer-api compose"
+//	raw         hex network messages for factom.SendRawMsg (send-raw-message)
+//	factom-cli  an addentry command; walletd must hold the EC address
+//
+// The jsonrpc fields are in every response, the others only add to them.
+const (
+	FormatJSONRPC   = "jsonrpc"
+	FormatCurl      = "curl"
+	FormatRaw       = "raw"
+	FormatFactomCLI = "factom-cli"
+)
+
+func checkFormat(format string) error {
+	switch format {
+	case "", FormatJSONRPC, FormatCurl, FormatRaw, FormatFactomCLI:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unknown format %s, use %s, %s, %s or %s", format, FormatJSONRPC, FormatCurl, FormatRaw, FormatFactomCLI))
+}
+
+// factomd message types, from factomd's common/constants
+const (
+	commitEntryMsgType = 6
+	revealEntryMsgType = 13
+)
+
+// Fills in the fields of r for format.
+func (r *addressResponse) applyFormat(format string, factomdServer string) error {
+	switch format {
+	case "", FormatJSONRPC:
+		return nil
+	case FormatCurl:
+		r.Output = GetCurlOutputForComposition(r.EntryCommitJson, r.RevealJson, r.TargetPriceInDollars, r.ECAddress, factomdServer)
+	case FormatRaw:
+		commit, err := rawCommitMessage(r.EntryCommitJson)
+		if err != nil {
+			return err
+		}
+		reveal, err := rawRevealMessage(r.RevealJson, time.Now())
+		if err != nil {
+			return err
+		}
+		r.CommitMessage, r.RevealMessage = commit, reveal
+	case FormatFactomCLI:
+		command, err := factomCLICommand(r.RevealJson, r.ECAddress, factomdServer)
+		if err != nil {
+			return err
+		}
+		r.Output = command
+	default:
+		return errors.New(fmt.Sprintf("Unknown format %s", format))
+	}
+	r.Format = format
+	return nil
+}
+
+// Decodes the hex param name of a JSON-RPC request made by ComposeFEREntry
+func jsonRPCHexParam(request string, name string) ([]byte, error) {
+	var req struct {
+		Params map[string]string `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(request), &req); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(req.Params[name])
+	if err != nil || len(b) == 0 {
+		return nil, errors.New(fmt.Sprintf("Request has no %s", name))
+	}
+	return b, nil
+}
+
+// A CommitEntryMsg: the type and the commit as sent to commit-entry.
+func rawCommitMessage(commitJson string) (string, error) {
+	commit, err := jsonRPCHexParam(commitJson, "message")
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(append([]byte{commitEntryMsgType}, commit...)), nil
+}
+
+// A RevealEntryMsg: the type, a 6 byte millisecond timestamp and the entry.
+func rawRevealMessage(revealJson string, t time.Time) (string, error) {
+	entry, err := jsonRPCHexParam(revealJson, "entry")
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(revealEntryMsgType)
+	milliTime := new(bytes.Buffer)
+	binary.Write(milliTime, binary.BigEndian, t.UnixNano()/1e6)
+	buf.Write(milliTime.Bytes()[2:])
+	buf.Write(entry)
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// factom-cli makes its own commit, paid by the EC address in walletd, and
+// reveals the same entry.
+func factomCLICommand(revealJson string, ecAddress string, factomdServer string) (string, error) {
+	b, err := jsonRPCHexParam(revealJson, "entry")
+	if err != nil {
+		return "", err
+	}
+	e, err := entrybinary.Unmarshal(b)
+	if err != nil {
+		return "", err
+	}
+	var ext []string
+	for _, id := range e.ExtIDs {
+		ext = append(ext, "-x "+hex.EncodeToString(id))
+	}
+	return fmt.Sprintf("echo -n %s | factom-cli -s %s addentry -c %s %s %s\n",
+		shellQuote(string(e.Content)), factomdServer, e.ChainID, strings.Join(ext, " "), ecAddress), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"github.com/FactomProject/web"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// /healthz answers as long as the process serves requests.  /readyz also
+// checks everything a rate change depends on and returns 503 when any check
+// fails, with a per check breakdown.
+
+// factomd calls have no timeout of their own, so each check gets this long
+const readyCheckTimeout = 5 * time.Second
+
+type checkResult struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type readyResponse struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// The last leader height readyz saw and when it last moved
+var leaderProgress struct {
+	sync.Mutex
+	height  int64
+	changed time.Time
+}
+
+func handleHealthz(ctx *web.Context) {
+	writeJSON(ctx, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleReadyz(ctx *web.Context) {
+	resp := readyResponse{Ready: true, Checks: make(map[string]checkResult)}
+	record := func(name string, err error) {
+		result := checkResult{OK: err == nil}
+		if err != nil {
+			result.Detail = err.Error()
+			resp.Ready = false
+		}
+		resp.Checks[name] = result
+	}
+
+	config, err := readConfigFile(configFileName)
+	record("config", err)
+	if err != nil {
+		writeJSON(ctx, http.StatusServiceUnavailable, resp)
+		return
+	}
+	record("keys", checkKeys(config))
+
+	factom.SetFactomdServer(config.GetFactomdServer())
+	// Buffered so a call that outlives its timeout has somewhere to put its result
+	heightsResult := make(chan *factom.HeightsResponse, 1)
+	record("factomd", withTimeout(func() error {
+		defer observeFactomd("heights", time.Now())
+		heights, err := factom.GetHeights()
+		heightsResult <- heights
+		return err
+	}))
+	var heights *factom.HeightsResponse
+	select {
+	case heights = <-heightsResult:
+	default:
+	}
+	if heights != nil {
+		record("leader-height", checkLeaderProgress(config, heights.LeaderHeight, time.Now()))
+	} else {
+		record("leader-height", errors.New("No heights from factomd"))
+	}
+	record("fer-chain", withTimeout(func() error {
+		defer observeFactomd("chain-head", time.Now())
+		if !factom.ChainExists(FERChainID) {
+			return errors.New(fmt.Sprintf("FER chain %s not found", FERChainID))
+		}
+		return nil
+	}))
+	record("ec-balance", withTimeout(func() error { return checkECBalance(config) }))
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(ctx, status, resp)
+}
+
+func checkKeys(config Config) error {
+	if _, err := config.GetPaymentSigner(); err != nil {
+		return err
+	}
+	_, err := config.GetSigningPublicKey()
+	return err
+}
+
+// Fails when the leader height hasn't moved for LeaderStallTimeout.
+func checkLeaderProgress(config Config, height int64, now time.Time) error {
+	leaderProgress.Lock()
+	defer leaderProgress.Unlock()
+
+	if height != leaderProgress.height || leaderProgress.changed.IsZero() {
+		leaderProgress.height = height
+		leaderProgress.changed = now
+		return nil
+	}
+	if stalled := now.Sub(leaderProgress.changed); stalled > config.GetLeaderStallTimeout() {
+		return errors.New(fmt.Sprintf("Leader height stuck at %d for %s", height, stalled.Truncate(time.Second)))
+	}
+	return nil
+}
+
+func checkECBalance(config Config) error {
+	address, err := config.GetPaymentECAddress()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	balance, err := factom.GetECBalance(address)
+	observeFactomd("entry-credit-balance", start)
+	if err != nil {
+		return err
+	}
+	if balance < config.MinECBalance {
+		return errors.New(fmt.Sprintf("%s has %d EC, below the minimum of %d", address, balance, config.MinECBalance))
+	}
+	return nil
+}
+
+func withTimeout(check func() error) error {
+	result := make(chan error, 1)
+	go func() { result <- check() }()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(readyCheckTimeout):
+		return errors.New(fmt.Sprintf("Timed out after %s", readyCheckTimeout))
+	}
+}
+
+func writeJSON(ctx *web.Context, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body = []byte(`{"error":"could not encode response"}`)
+	}
+	ctx.SetHeader("Content-Type", "application/json", true)
+	ctx.WriteHeader(status)
+	ctx.Write(body)
+}
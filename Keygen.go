@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io/ioutil"
+	"os"
+)
+
+// The factomd.conf setting holding the FER signing public key, per network
+var authorityKeySettings = map[string]string{
+	"main":   "ExchangeRateAuthorityPublicKeyMainNet",
+	"test":   "ExchangeRateAuthorityPublicKeyTestNet",
+	"local":  "ExchangeRateAuthorityPublicKeyLocalNet",
+	"custom": "ExchangeRateAuthorityPublicKey",
+}
+
+// The factoid address holding the genesis balance of a LOCAL network
+const localNetFactoidAddress = "FA2jK2HcLnRdS94dEcU27rF3meoJfpUcZPSinpb7AwQvPRY6RL1Q"
+
+// "fer-api keygen" makes a new FER signing key and EC payment key and puts
+// them in the config file, as hex or in keystores.  A config file that
+// already has keys is left alone.
+func runKeygenCommand(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	conf := flags.String("config", configFileName, "config file to create or add the keys to")
+	useKeystore := flags.Bool("keystore", false, "write the keys to passphrase encrypted keystores instead of the config")
+	signingKeystore := flags.String("signing-keystore", "signing.keystore", "keystore file for the signing key")
+	paymentKeystore := flags.String("payment-keystore", "payment.keystore", "keystore file for the payment key")
+	network := flags.String("network", "local", "factomd network the snippet is for: local, test, main or custom")
+	flags.Parse(args)
+
+	setting, ok := authorityKeySettings[*network]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown network %s", *network))
+	}
+
+	existing, err := ioutil.ReadFile(*conf)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var config Config
+	if _, err := toml.Decode(string(existing), &config); err != nil {
+		return errors.New(fmt.Sprintf("Error reading your file: %s  Error: %s", *conf, tomlErrorText(err)))
+	}
+	if config.hasPaymentKey() || config.hasSigningKey() || config.SigningPublicKey != "" {
+		return errors.New(fmt.Sprintf("%s already has keys, remove them or give another -config", *conf))
+	}
+
+	signingPublic, signingPrivate, err := ed.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	paymentPublic, paymentPrivate, err := ed.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	// Keys go before everything else in the file, where they can't end up in
+	// a table; backend tables go after it.
+	var top, tables bytes.Buffer
+	// Keystores written so far, removed again if the config can't be
+	// written, so a rerun doesn't trip over them
+	var written []string
+	defer func() {
+		for _, path := range written {
+			os.Remove(path)
+		}
+	}()
+	if config.Version == "" {
+		top.WriteString("Version = \"1.0\"\n")
+	}
+	fmt.Fprintf(&top, "SigningPublicKey = %q\n", hex.EncodeToString(signingPublic[:]))
+	if *useKeystore {
+		passphrase, err := readSecret("Keystore passphrase: ")
+		if err != nil {
+			return err
+		}
+		repeat, err := readSecret("Repeat passphrase: ")
+		if err != nil {
+			return err
+		}
+		if len(passphrase) == 0 || string(passphrase) != string(repeat) {
+			return errors.New("Passphrases are empty or don't match")
+		}
+		if err := writeKeystore(*signingKeystore, signingPrivate[:32], passphrase); err != nil {
+			return err
+		}
+		written = append(written, *signingKeystore)
+		if err := writeKeystore(*paymentKeystore, paymentPrivate[:32], passphrase); err != nil {
+			return err
+		}
+		written = append(written, *paymentKeystore)
+		fmt.Fprintf(&tables, "\n[SigningKeyBackend]\nType = \"keystore\"\nKeystore = %q\n", *signingKeystore)
+		fmt.Fprintf(&tables, "\n[PaymentKeyBackend]\nType = \"keystore\"\nKeystore = %q\n", *paymentKeystore)
+	} else {
+		fmt.Fprintf(&top, "SigningPrivateKey = %q\n", hex.EncodeToString(signingPrivate[:32]))
+		fmt.Fprintf(&top, "PaymentPrivateKey = %q\n", hex.EncodeToString(paymentPrivate[:32]))
+	}
+
+	content := append(top.Bytes(), existing...)
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	content = append(content, tables.Bytes()...)
+	tmp := *conf + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *conf); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	written = nil
+
+	ecAddress := ecPublicAddress(paymentPublic)
+	fmt.Printf("Wrote the keys to %s\n", *conf)
+	if *useKeystore {
+		fmt.Printf("Keystores %s and %s; set $%s to the passphrase for the server\n", *signingKeystore, *paymentKeystore, defaultPassphraseEnv)
+	}
+	fmt.Println()
+	fmt.Printf("EC address to fund:  %s\n", ecAddress)
+	if *network == "local" {
+		fmt.Printf("    factom-cli buyec %s %s 10000\n", localNetFactoidAddress, ecAddress)
+	}
+	fmt.Printf("Signing public key:  %s\n", hex.EncodeToString(signingPublic[:]))
+	fmt.Println()
+	fmt.Println("factomd.conf, in the [app] section:")
+	fmt.Printf("    %s = \"%s\"\n", setting, hex.EncodeToString(signingPublic[:]))
+	return nil
+}
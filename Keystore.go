@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A keystore is a JSON file holding one private key, encrypted with
+// nacl/secretbox under a key derived from a passphrase with scrypt.
+
+const defaultPassphraseEnv = "FER_KEYSTORE_PASSPHRASE"
+
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	PublicKey  string `json:"public-key"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Keystores are decrypted once; scrypt is too slow to run on every request.
+var keystoreCache = struct {
+	sync.Mutex
+	signers map[string]*memorySigner
+}{signers: make(map[string]*memorySigner)}
+
+func keystoreKey(passphrase []byte, salt []byte, n, r, p int) (*[32]byte, error) {
+	k, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+func openKeystore(fileName string, passphraseEnv string) (Signer, error) {
+	keystoreCache.Lock()
+	defer keystoreCache.Unlock()
+
+	if s, ok := keystoreCache.signers[fileName]; ok {
+		return s, nil
+	}
+
+	if passphraseEnv == "" {
+		passphraseEnv = defaultPassphraseEnv
+	}
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		return nil, errors.New(fmt.Sprintf("No keystore passphrase in $%s", passphraseEnv))
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read keystore %s", fileName))
+	}
+	ks := new(keystoreFile)
+	if err := json.Unmarshal(data, ks); err != nil || ks.KDF != "scrypt" {
+		return nil, errors.New(fmt.Sprintf("Keystore %s isn't parsable", fileName))
+	}
+	salt, err1 := hex.DecodeString(ks.Salt)
+	nonceBytes, err2 := hex.DecodeString(ks.Nonce)
+	ciphertext, err3 := hex.DecodeString(ks.Ciphertext)
+	if err1 != nil || err2 != nil || err3 != nil || len(nonceBytes) != 24 {
+		return nil, errors.New(fmt.Sprintf("Keystore %s isn't parsable", fileName))
+	}
+	var nonce [24]byte
+	copy(nonce[:], nonceBytes)
+
+	key, err := keystoreKey([]byte(passphrase), salt, ks.N, ks.R, ks.P)
+	if err != nil {
+		return nil, err
+	}
+	seed, ok := secretbox.Open(nil, ciphertext, &nonce, key)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Wrong passphrase for keystore %s", fileName))
+	}
+
+	s, err := newMemorySigner(hex.EncodeToString(seed))
+	if err != nil {
+		return nil, err
+	}
+	keystoreCache.signers[fileName] = s
+	return s, nil
+}
+
+func writeKeystore(fileName string, seed []byte, passphrase []byte) error {
+	var privateKey [ed.PrivateKeySize]byte
+	copy(privateKey[:], seed)
+	publicKey := ed.GetPublicKey(&privateKey)
+
+	ks := &keystoreFile{Version: 1, KDF: "scrypt", N: 1 << 15, R: 8, P: 1}
+	salt := make([]byte, 32)
+	var nonce [24]byte
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	key, err := keystoreKey(passphrase, salt, ks.N, ks.R, ks.P)
+	if err != nil {
+		return err
+	}
+
+	ks.PublicKey = hex.EncodeToString(publicKey[:])
+	ks.Salt = hex.EncodeToString(salt)
+	ks.Nonce = hex.EncodeToString(nonce[:])
+	ks.Ciphertext = hex.EncodeToString(secretbox.Seal(nil, seed[:32], &nonce, key))
+
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not create keystore %s: %s", fileName, err))
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName)
+	}
+	return err
+}
+
+// Reads a line without echoing it when stdin is a terminal.
+func readSecret(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		secret, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		return secret, err
+	}
+	text, err := stdinReader.ReadString('\n')
+	if err != nil && text == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(text, "\r\n")), nil
+}
+
+// "fer-api keystore <file>" encrypts a hex private key into a new keystore.
+func runKeystoreCommand(args []string) error {
+	flags := flag.NewFlagSet("keystore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Println("Usage: fer-api keystore <file>")
+		fmt.Println("  Prompts for a hex private key and a passphrase and writes them encrypted to <file>")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("Expected the keystore file name")
+	}
+
+	hexKey, err := readSecret("Private key (hex): ")
+	if err != nil {
+		return err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(hexKey)))
+	if err != nil || len(seed) < 32 {
+		return errors.New("Private key isn't parsable")
+	}
+	passphrase, err := readSecret("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	repeat, err := readSecret("Repeat passphrase: ")
+	if err != nil {
+		return err
+	}
+	if len(passphrase) == 0 || string(passphrase) != string(repeat) {
+		return errors.New("Passphrases are empty or don't match")
+	}
+
+	if err := writeKeystore(flags.Arg(0), seed, passphrase); err != nil {
+		return err
+	}
+	fmt.Println("Wrote keystore", flags.Arg(0))
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/FactomProject/factom"
+	"time"
+)
+
+// Follows submitted entries until they activate or expire:
+//
+//	submitted -> acknowledged -> confirmed -> activated
+//	                                 \------> expired
+//
+// acknowledged and confirmed come from factomd's entry-ack (TransactionACK and
+// DBlockConfirmed).  A confirmed entry is activated once the leader height
+// reaches its activation height and factom.GetRate equals its TargetPrice.  An
+// entry is expired when it isn't in a directory block by its expiration
+// height, or the rate hasn't become its TargetPrice activationGrace blocks
+// after activation (another entry won, or it was never accepted).
+const activationGrace = 2
+
+// Entry states past submitted, set by the lifecycle tracker
+const (
+	EntryAcknowledged = "acknowledged"
+	EntryConfirmed    = "confirmed"
+	EntryActivated    = "activated"
+	EntryExpired      = "expired"
+)
+
+// A submitted entry that hasn't activated or expired yet
+func (e *StoredEntry) inFlight() bool {
+	return e.State == EntrySubmitted || e.State == EntryAcknowledged || e.State == EntryConfirmed
+}
+
+func runLifecycleTracker(interval time.Duration) {
+	for {
+		if err := trackEntryLifecycles(); err != nil {
+			logger.Warn("Lifecycle tracking failed", "component", "lifecycle", "error", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func trackEntryLifecycles() error {
+	if entryStore == nil {
+		return nil
+	}
+	list, err := entryStore.List(EntryFilter{})
+	if err != nil {
+		return err
+	}
+	inFlight := make([]*StoredEntry, 0)
+	for _, e := range list {
+		if e.inFlight() {
+			inFlight = append(inFlight, e)
+		}
+	}
+	if len(inFlight) == 0 {
+		return nil
+	}
+
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return err
+	}
+	factom.SetFactomdServer(config.GetFactomdServer())
+	start := time.Now()
+	heights, err := factom.GetHeights()
+	observeFactomd("heights", start)
+	if err != nil {
+		return err
+	}
+	start = time.Now()
+	rate, err := factom.GetRate()
+	observeFactomd("entry-credit-rate", start)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range inFlight {
+		state := nextEntryState(e, heights.LeaderHeight, rate)
+		if state == e.State {
+			continue
+		}
+		if _, err := entryStore.Update(e.EntryHash, func(e *StoredEntry) { e.setState(state, time.Now().UTC()) }); err != nil {
+			return err
+		}
+		logger.Info("Entry state changed", "component", "lifecycle", "entry_hash", e.EntryHash, "from", e.State, "state", state)
+		data := map[string]interface{}{"fer-entry": e.FEREntry, "leader-height": heights.LeaderHeight, "rate": rate}
+		for _, passed := range statesPassed(e.State, state) {
+			publishEvent(lifecycleEvents[passed], e.EntryHash, data)
+		}
+	}
+	return nil
+}
+
+var lifecycleEvents = map[string]string{
+	EntryAcknowledged: EventAcknowledged,
+	EntryConfirmed:    EventConfirmed,
+	EntryActivated:    EventActivated,
+	EntryExpired:      EventExpired,
+}
+
+var lifecycleOrder = []string{EntrySubmitted, EntryAcknowledged, EntryConfirmed, EntryActivated}
+
+// An entry can move more than one step in a pass; every state it went
+// through gets its event.
+func statesPassed(from string, to string) []string {
+	if to == EntryExpired {
+		return []string{EntryExpired}
+	}
+	passed := make([]string, 0)
+	seen := false
+	for _, state := range lifecycleOrder {
+		if seen {
+			passed = append(passed, state)
+		}
+		if state == from {
+			seen = true
+		}
+		if state == to {
+			break
+		}
+	}
+	return passed
+}
+
+func nextEntryState(e *StoredEntry, leaderHeight int64, rate uint64) string {
+	state := e.State
+	if state == EntrySubmitted || state == EntryAcknowledged {
+		start := time.Now()
+		status, err := factom.EntryACK(e.EntryHash, "")
+		observeFactomd("entry-ack", start)
+		if err == nil {
+			switch status.EntryData.Status {
+			case "TransactionACK":
+				state = EntryAcknowledged
+			case "DBlockConfirmed":
+				state = EntryConfirmed
+			}
+		}
+		if state != EntryConfirmed && leaderHeight > int64(e.FEREntry.ExpirationHeight) {
+			return EntryExpired
+		}
+	}
+	if state == EntryConfirmed && leaderHeight >= int64(e.FEREntry.TargetActivationHeight) {
+		if rate == e.FEREntry.TargetPrice {
+			return EntryActivated
+		}
+		if leaderHeight >= int64(e.FEREntry.TargetActivationHeight)+activationGrace {
+			return EntryExpired
+		}
+	}
+	return state
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Structured logging: one JSON object per line with time, level, msg and the
+// caller, plus whatever fields the logger carries (request_id, method, ...).
+//
+// Private keys must never reach a log line.  Errors about keys are written so
+// they don't echo the key, and as a second line of defence any field whose
+// name looks like key material is replaced with "[redacted]".
+
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+var (
+	logLevel            = LevelInfo
+	logOutput io.Writer = os.Stdout
+	logLock   sync.Mutex
+)
+
+type Logger struct {
+	fields []interface{}
+}
+
+// The logger with no fields
+var logger = new(Logger)
+
+func setLogLevel(name string) error {
+	for i, n := range levelNames {
+		if n == name {
+			logLevel = i
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("Unknown log level %s, use one of %s", name, strings.Join(levelNames, ", ")))
+}
+
+// With returns a logger that adds the key value pairs to every line.
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyValues...)
+	return &Logger{fields: fields}
+}
+
+func (l *Logger) Debug(msg string, keyValues ...interface{}) { l.log(LevelDebug, msg, keyValues) }
+func (l *Logger) Info(msg string, keyValues ...interface{})  { l.log(LevelInfo, msg, keyValues) }
+func (l *Logger) Warn(msg string, keyValues ...interface{})  { l.log(LevelWarn, msg, keyValues) }
+func (l *Logger) Error(msg string, keyValues ...interface{}) { l.log(LevelError, msg, keyValues) }
+
+// Called from Debug, Info, Warn or Error, so the caller is two frames up.
+func (l *Logger) log(level int, msg string, keyValues []interface{}) {
+	if level < logLevel {
+		return
+	}
+	_, file, line, ok := runtime.Caller(2)
+	l.output(level, callerName(file, line, ok), msg, keyValues)
+}
+
+// Finds the caller of a log.Logger method.  The frames of this file and of
+// the log package come first, however many the Go release uses.
+func stdLoggerCaller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "log.") && !strings.HasSuffix(frame.Function, ".logWriter.Write") {
+			return callerName(frame.File, frame.Line, frame.File != "")
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+func callerName(file string, line int, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}
+
+func (l *Logger) output(level int, caller string, msg string, keyValues []interface{}) {
+	if level < logLevel {
+		return
+	}
+
+	record := make(map[string]interface{})
+	addFields(record, l.fields)
+	addFields(record, keyValues)
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = levelNames[level]
+	record["msg"] = msg
+	if caller != "" {
+		record["caller"] = caller
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"error","msg":"could not encode log record: %s"}`, err))
+	}
+
+	logLock.Lock()
+	defer logLock.Unlock()
+	logOutput.Write(append(line, '\n'))
+}
+
+func addFields(record map[string]interface{}, keyValues []interface{}) {
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		key := fmt.Sprint(keyValues[i])
+		value := keyValues[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		if isSecretField(key) {
+			value = "[redacted]"
+		}
+		record[key] = value
+	}
+}
+
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"private", "secret", "passphrase", "password", "seed"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Takes the request id from the caller, or makes one up.  Anything odd in the
+// header is replaced, so it can be trusted in log lines and responses.
+func requestID(header string) string {
+	if header != "" && len(header) <= 128 && !strings.ContainsAny(header, "\r\n\"\\") {
+		return header
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Adapts a log.Logger (used by the web server) to the structured log.
+type logWriter struct {
+	logger *Logger
+	level  int
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if w.level < logLevel {
+		return len(p), nil
+	}
+	w.logger.output(w.level, stdLoggerCaller(), strings.TrimSpace(string(p)), nil)
+	return len(p), nil
+}
+
+// Results that carry a composed entry expose its hash for the request log
+type entryHasher interface {
+	entryHash() string
+}
+
+func (r *addressResponse) entryHash() string {
+	return entryHashFromReveal(r.RevealJson)
+}
+
+func (p *Proposal) entryHash() string {
+	if p.Result == nil {
+		return ""
+	}
+	return p.Result.entryHash()
+}
+
+func (s *ScheduledChange) entryHash() string {
+	if s.Result == nil {
+		return ""
+	}
+	return s.Result.entryHash()
+}
+
+// The entry hash of the entry inside a reveal-entry JSON-RPC request.
+func entryHashFromReveal(revealJson string) string {
+	var reveal struct {
+		Params struct {
+			Entry string `json:"entry"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(revealJson), &reveal); err != nil {
+		return ""
+	}
+	entry, err := hex.DecodeString(reveal.Params.Entry)
+	if err != nil || len(entry) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(entryHash(entry))
+}
+
+// sha256(sha512(entry) + entry), as in factom.Entry.Hash
+func entryHash(entry []byte) []byte {
+	h512 := sha512.Sum512(entry)
+	h256 := sha256.Sum256(append(h512[:], entry...))
+	return h256[:]
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/fer-api/internal/entrybinary"
+	"github.com/FactomProject/web"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /metrics in the Prometheus text format.  The handful of series here are
+// kept by hand rather than pulling the Prometheus client into vendor/.
+
+var (
+	metricComposed       = newCounterVec("fer_api_compose_total", "FER entries composed.", "method")
+	metricSubmitted      = newCounterVec("fer_api_submit_total", "FER entries submitted to factomd.", "method")
+	metricErrors         = newCounterVec("fer_api_errors_total", "JSON-RPC requests answered with an error.", "method", "code")
+	metricRequestSeconds = newHistogramVec("fer_api_request_duration_seconds", "JSON-RPC request latency.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "method")
+	metricFactomdSeconds = newHistogramVec("fer_api_factomd_request_duration_seconds", "Latency of calls to factomd.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "call")
+
+	metricECBalance       = newGauge("fer_api_ec_balance", "Entry credits on the payment EC address.")
+	metricECRate          = newGauge("fer_api_network_ec_rate", "Current factoshis per EC from factomd (entry-credit-rate).")
+	metricLeaderHeight    = newGauge("fer_api_leader_height", "Current factomd leader height.")
+	metricLastSignedPrice = newGauge("fer_api_last_signed_target_price", "TargetPrice of the most recently signed FER entry.")
+	metricPendingEntries  = newGauge("fer_api_pending_fer_entries", "Submitted FER entries whose activation height is not reached yet.")
+	metricFeedPrice       = newGauge("fer_api_price_feed_fct_usd", "Last FCT/USD price read by the price feed.")
+
+	metrics = []metricWriter{metricComposed, metricSubmitted, metricErrors, metricRequestSeconds, metricFactomdSeconds,
+		metricECBalance, metricECRate, metricLeaderHeight, metricLastSignedPrice, metricPendingEntries, metricFeedPrice}
+)
+
+type metricWriter interface {
+	write(w io.Writer)
+}
+
+func handleMetrics(ctx *web.Context) {
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	ctx.SetHeader("Content-Type", "text/plain; version=0.0.4", true)
+	ctx.Write(buf.Bytes())
+}
+
+// Times a call to factomd, labelled by its API method
+func observeFactomd(call string, start time.Time) {
+	metricFactomdSeconds.observe(time.Since(start).Seconds(), call)
+}
+
+// Submitted entries not yet activated, for fer_api_pending_fer_entries.
+// Only entries that went to factomd count; composing alone changes nothing.
+var pendingFEREntries = struct {
+	sync.Mutex
+	entries []FEREntry
+}{}
+
+func trackSubmittedFEREntry(revealJson string) {
+	content, err := jsonRPCHexParam(revealJson, "entry")
+	if err == nil {
+		var e *factom.Entry
+		if e, err = entrybinary.Unmarshal(content); err == nil {
+			var fer FEREntry
+			if err = json.Unmarshal(e.Content, &fer); err == nil {
+				addPendingFEREntry(fer)
+				return
+			}
+		}
+	}
+	logger.Warn("Could not read submitted entry for metrics", "error", err)
+}
+
+func addPendingFEREntry(fer FEREntry) {
+	pendingFEREntries.Lock()
+	defer pendingFEREntries.Unlock()
+	pendingFEREntries.entries = append(pendingFEREntries.entries, fer)
+}
+
+// Refills the pending entries and last signed price from the entry store,
+// so a restart doesn't reset them.  Every stored entry was signed.
+func loadSubmittedFEREntries(store *EntryStore) error {
+	all, err := store.List(EntryFilter{})
+	if err != nil {
+		return err
+	}
+	var lastSigned *StoredEntry
+	for _, e := range all {
+		if lastSigned == nil || e.CreatedAt.After(lastSigned.CreatedAt) {
+			lastSigned = e
+		}
+	}
+	if lastSigned != nil {
+		metricLastSignedPrice.set(float64(lastSigned.FEREntry.TargetPrice))
+	}
+
+	pendingFEREntries.Lock()
+	defer pendingFEREntries.Unlock()
+	for _, e := range all {
+		if !e.StateTimes[EntrySubmitted].IsZero() && e.inFlight() {
+			pendingFEREntries.entries = append(pendingFEREntries.entries, e.FEREntry)
+		}
+	}
+	return nil
+}
+
+// Drops entries at or past their activation height and returns what is left.
+func countPendingFEREntries(leaderHeight int64) int {
+	pendingFEREntries.Lock()
+	defer pendingFEREntries.Unlock()
+
+	pending := pendingFEREntries.entries[:0]
+	for _, fer := range pendingFEREntries.entries {
+		if int64(fer.TargetActivationHeight) > leaderHeight && int64(fer.ExpirationHeight) >= leaderHeight {
+			pending = append(pending, fer)
+		}
+	}
+	pendingFEREntries.entries = pending
+	return len(pending)
+}
+
+// Refreshes the gauges that come from factomd every interval, forever.
+func collectMetrics(interval time.Duration) {
+	for {
+		updateFactomdGauges()
+		time.Sleep(interval)
+	}
+}
+
+func updateFactomdGauges() {
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return
+	}
+	factom.SetFactomdServer(config.GetFactomdServer())
+
+	start := time.Now()
+	heights, err := factom.GetHeights()
+	observeFactomd("heights", start)
+	if err != nil {
+		logger.Warn("Could not read heights for metrics", "error", err)
+	} else {
+		metricLeaderHeight.set(float64(heights.LeaderHeight))
+		metricPendingEntries.set(float64(countPendingFEREntries(heights.LeaderHeight)))
+	}
+
+	start = time.Now()
+	rate, err := factom.GetRate()
+	observeFactomd("entry-credit-rate", start)
+	if err == nil {
+		metricECRate.set(float64(rate))
+	}
+
+	if address, err := config.GetPaymentECAddress(); err == nil {
+		start = time.Now()
+		balance, err := factom.GetECBalance(address)
+		observeFactomd("entry-credit-balance", start)
+		if err == nil {
+			metricECBalance.set(float64(balance))
+		}
+	}
+}
+
+type counterVec struct {
+	sync.Mutex
+	name, help string
+	labels     []string
+	values     map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[formatLabels(c.labels, labelValues)]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, l := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, l, formatValue(c.values[l]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	sync.Mutex
+	name, help string
+	labels     []string
+	buckets    []float64
+	values     map[string]*histogram
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.Lock()
+	defer h.Unlock()
+	key := formatLabels(h.labels, labelValues)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, l := range keys {
+		hist := h.values[l]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(l, "le", formatValue(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(l, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, l, formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, l, hist.count)
+	}
+}
+
+type gauge struct {
+	sync.Mutex
+	name, help string
+	value      float64
+	isSet      bool
+}
+
+func newGauge(name string, help string) *gauge {
+	return &gauge{name: name, help: help}
+}
+
+func (g *gauge) set(v float64) {
+	g.Lock()
+	defer g.Unlock()
+	g.value = v
+	g.isSet = true
+}
+
+// A gauge is left out until it has a value, so a missing factomd reading
+// doesn't show up as 0.
+func (g *gauge) write(w io.Writer) {
+	g.Lock()
+	defer g.Unlock()
+	if !g.isSet {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.value))
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Adds one more label to an already formatted label set
+func withLabel(labels string, name string, value string) string {
+	pair := fmt.Sprintf("%s=\"%s\"", name, value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
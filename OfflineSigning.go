@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Offline signing keeps the SigningPrivateKey off the networked host:
+//   1. prepare-fer-entry (online) returns the exact FEREntry bytes to sign
+//   2. "fer-api sign <content-hex>" (offline) prints the ed25519 signature
+//   3. finalize-fer-entry (online) checks the signature against the
+//      configured public key and composes the commit and reveal
+
+type prepareResponse struct {
+	Content              string  `json:"content"`
+	ContentHex           string  `json:"content-hex"`
+	SigningPublicKey     string  `json:"signing-public-key"`
+	TargetPriceInDollars float64 `json:"target-price-in-dollars"`
+}
+
+type finalizeRequest struct {
+	ContentHex string `json:"content-hex"`
+	Signature  string `json:"signature"`
+	Format     string `json:"format"`
+	// Unix milliseconds for the commit, only with -deterministic
+	CommitTimestamp string `json:"commit-timestamp"`
+}
+
+func handlePrepareFEREntry(params []byte) (interface{}, *factom.JSONError) {
+	change := new(ChangeResponse)
+	if err := json.Unmarshal(params, change); err != nil {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	if config.ApprovalsRequired > 0 {
+		return nil, newCustomInternalError("FER changes require approval, use propose-fer-change")
+	}
+	publicKey, err := config.GetSigningPublicKey()
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+
+	theFEREntry, err := NewFEREntry(config.Version, change.ExpirationHeight, change.ActivationHeight, change.Priority, change.NewPricePerEC, change.FERMetadata)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	entryJson, err := json.Marshal(theFEREntry)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+
+	r := new(prepareResponse)
+	r.Content = string(entryJson)
+	r.ContentHex = hex.EncodeToString(entryJson)
+	r.SigningPublicKey = hex.EncodeToString(publicKey[:])
+	r.TargetPriceInDollars = 100000 / float64(theFEREntry.TargetPrice)
+	return r, nil
+}
+
+func handleFinalizeFEREntry(params []byte) (interface{}, *factom.JSONError) {
+	req := new(finalizeRequest)
+	if err := json.Unmarshal(params, req); err != nil {
+		return nil, newInvalidParamsError()
+	}
+	if err := checkFormat(req.Format); err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	if config.ApprovalsRequired > 0 {
+		return nil, newCustomInternalError("FER changes require approval, use propose-fer-change")
+	}
+
+	entryJson, err := hex.DecodeString(req.ContentHex)
+	if err != nil {
+		return nil, newInvalidParamsError()
+	}
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil {
+		return nil, newInvalidParamsError()
+	}
+	if err := verifyFERSignature(config, entryJson, signature); err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+
+	commitTime, err := commitTimeParam(req.CommitTimestamp)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	entry, reveal, targetPriceInDollars, ecAddress, err := ComposeFEREntryAt(config, entryJson, signature, commitTime)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+
+	metricComposed.inc("finalize-fer-entry")
+
+	r := new(addressResponse)
+	r.EntryCommitJson = entry
+	r.RevealJson = reveal
+	r.TargetPriceInDollars = targetPriceInDollars
+	r.ECAddress = ecAddress
+	if err := r.applyFormat(req.Format, config.GetFactomdServer()); err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return r, nil
+}
+
+// Checks a signature over the FEREntry content against the configured
+// signing public key.
+func verifyFERSignature(config Config, entryJson []byte, signature []byte) error {
+	publicKey, err := config.GetSigningPublicKey()
+	if err != nil {
+		return err
+	}
+	if len(signature) != ed.SignatureSize {
+		return errors.New("Signature must be an ed25519 signature")
+	}
+	var sig [ed.SignatureSize]byte
+	copy(sig[:], signature)
+	if !ed.VerifyCanonical(publicKey, entryJson, &sig) {
+		return errors.New("Signature does not match the configured signing public key")
+	}
+	return nil
+}
+
+// "fer-api sign" runs on the offline machine.  It shows what is being signed
+// and prints the signature for finalize-fer-entry.  With -message it signs a
+// proposal vote instead, after showing the proposal it is for.
+func runSignCommand(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	conf := flags.String("config", configFileName, "config file holding the signing key")
+	message := flags.String("message", "", "sign this proposal vote, approve-proposal:<id> or reject-proposal:<id>, instead of FEREntry content")
+	proposalFile := flags.String("proposal", "", "with -message, the proposal as list-proposals returns it")
+	flags.Usage = func() {
+		fmt.Println("Usage: fer-api sign [-config file] <content-hex>")
+		fmt.Println("       fer-api sign [-config file] -message <approve-proposal:<id>|reject-proposal:<id>> -proposal <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// The offline machine only needs SigningPrivateKey, so skip the checks
+	// readConfigFile does for the server
+	var config Config
+	if _, err := toml.DecodeFile(*conf, &config); err != nil {
+		return errors.New(fmt.Sprintf("Error reading your file: %s  Error: %s", *conf, err))
+	}
+	signer, err := config.GetSigningSigner()
+	if err != nil {
+		return err
+	}
+	publicKey, err := signer.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	var toSign []byte
+	if *message != "" {
+		if err := showProposalVote(*message, *proposalFile); err != nil {
+			return err
+		}
+		toSign = []byte(*message)
+	} else {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("Expected the content hex from prepare-fer-entry")
+		}
+		toSign, err = hex.DecodeString(flags.Arg(0))
+		if err != nil {
+			return errors.New("Content isn't hex")
+		}
+		theFEREntry, err := decodeFEREntry(toSign)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Content:   %s\n", string(toSign))
+		fmt.Printf("Implied factoid price: $%.2f\n", 100000/float64(theFEREntry.TargetPrice))
+	}
+
+	signature, err := signer.Sign(toSign)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("PublicKey: %x\n", publicKey[:])
+	fmt.Printf("Signature: %x\n", signature[:])
+	return nil
+}
+
+var proposalVote = regexp.MustCompile(`^(approve-proposal|reject-proposal):([0-9a-f]{32})$`)
+
+// Only proposal votes are signed as messages, and only after the proposal
+// they are for is shown.  The proposal id is a hash of its content, so the
+// file can't show a different change than the one voted on.
+func showProposalVote(message string, proposalFile string) error {
+	match := proposalVote.FindStringSubmatch(message)
+	if match == nil {
+		return errors.New("-message must be approve-proposal:<proposal id> or reject-proposal:<proposal id>")
+	}
+	if proposalFile == "" {
+		return errors.New("-message needs -proposal, the proposal file to show what the vote is for")
+	}
+	data, err := ioutil.ReadFile(proposalFile)
+	if err != nil {
+		return err
+	}
+	p := new(Proposal)
+	if err := json.Unmarshal(data, p); err != nil {
+		return errors.New(fmt.Sprintf("%s is not a proposal: %s", proposalFile, err))
+	}
+	if p.ID != match[2] {
+		return errors.New(fmt.Sprintf("%s is proposal %s, not %s", proposalFile, p.ID, match[2]))
+	}
+	if id := newProposalID(p); id != p.ID {
+		return errors.New(fmt.Sprintf("Proposal %s does not match its content, which hashes to %s", p.ID, id))
+	}
+
+	fmt.Printf("Vote:      %s\n", strings.TrimSuffix(match[1], "-proposal"))
+	fmt.Printf("Proposal:  %s\n", p.ID)
+	fmt.Printf("Reason:    %s\n", p.Reason)
+	fmt.Printf("Expiration height: %s\n", p.ExpirationHeight)
+	fmt.Printf("Activation height: %s\n", p.ActivationHeight)
+	fmt.Printf("Priority:  %s\n", p.Priority)
+	fmt.Printf("New price per EC: %s\n", p.NewPricePerEC)
+	if price, err := strconv.ParseFloat(p.NewPricePerEC, 64); err == nil && price > 0 {
+		fmt.Printf("Implied factoid price: $%.2f\n", 100000/price)
+	}
+	if p.Submit {
+		fmt.Println("Submitted to factomd once approved")
+	}
+	fmt.Printf("Message:   %s\n", message)
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"strconv"
+	"time"
+)
+
+// An oracle chain price source reads FCT/USD from oracle records published as
+// entries on a Factom chain, so the feed needs nothing off chain.
+//
+// Anyone can write to a Factom chain, so only records signed by one of the
+// Signers count: ExtID 0 is the signer's ed25519 public key, ExtID 2 the
+// decimal height of the block the record is for and ExtID 1 the signature of
+// ExtID 2 followed by the content.  A record whose height isn't the height of
+// the entry block it is in is skipped, so an old record can't be replayed
+// into a new block.  Only the last record of each signer in a block counts,
+// so one key can't outvote the others.
+//
+// It walks the chain's entry blocks back from the head to the newest one at or
+// below Height (the newest block when Height is 0), decodes every signed entry
+// in it and takes the median of the prices as that block's consensus.  A block
+// with fewer than MinRecords signers is skipped for the one before it.  At
+// most MaxBlocks blocks are fetched, including those above Height.  The quote
+// time is the entry block's timestamp, so MaxAge applies to the oracle too.
+//
+// Decoder picks how an entry is read; see oracleDecoders.
+
+// An OracleDecoder reads the FCT/USD price out of one oracle record.
+type OracleDecoder interface {
+	Decode(e *factom.Entry) (float64, error)
+}
+
+// Decoders by name.  Another record format is added by adding to this map.
+var oracleDecoders = map[string]func(c PriceSourceConfig) (OracleDecoder, error){
+	// JSON content with the price at Path
+	"json": func(c PriceSourceConfig) (OracleDecoder, error) {
+		if c.Path == "" {
+			return nil, errors.New("The json oracle decoder needs a Path")
+		}
+		return jsonOracleDecoder{path: c.Path}, nil
+	},
+	// JSON content with the FCT price in USD under assets
+	"assets-json": func(c PriceSourceConfig) (OracleDecoder, error) {
+		path := c.Path
+		if path == "" {
+			path = "assets.FCT"
+		}
+		return jsonOracleDecoder{path: path}, nil
+	},
+}
+
+type jsonOracleDecoder struct {
+	path string
+}
+
+func (d jsonOracleDecoder) Decode(e *factom.Entry) (float64, error) {
+	quote, err := parseQuote(e.Content, d.path, "", time.Time{})
+	return quote.Price, err
+}
+
+type oraclePriceSource struct {
+	name       string
+	chainID    string
+	decoder    OracleDecoder
+	height     int64
+	minRecords int
+	maxBlocks  int
+	// Hex public keys whose records count
+	signers map[string]bool
+}
+
+func newOraclePriceSource(name string, c PriceSourceConfig) (*oraclePriceSource, error) {
+	if len(c.ChainID) != 64 {
+		return nil, errors.New("Oracle chain price source needs a 64 character ChainID")
+	}
+	decoderName := c.Decoder
+	if decoderName == "" {
+		decoderName = "json"
+	}
+	newDecoder, ok := oracleDecoders[decoderName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown oracle decoder: %s", decoderName))
+	}
+	decoder, err := newDecoder(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Signers) == 0 {
+		return nil, errors.New("Oracle chain price source needs Signers, the public keys whose records count")
+	}
+	signers := make(map[string]bool)
+	for _, key := range c.Signers {
+		keyBytes, err := hex.DecodeString(key)
+		if err != nil || len(keyBytes) != ed.PublicKeySize {
+			return nil, errors.New(fmt.Sprintf("Oracle signer %s isn't a hex ed25519 public key", key))
+		}
+		signers[hex.EncodeToString(keyBytes)] = true
+	}
+	s := &oraclePriceSource{name: name, chainID: c.ChainID, decoder: decoder, height: c.Height, minRecords: c.MinRecords, maxBlocks: c.MaxBlocks, signers: signers}
+	if s.minRecords < 1 {
+		s.minRecords = 1
+	}
+	if s.maxBlocks < 1 {
+		s.maxBlocks = 10
+	}
+	return s, nil
+}
+
+func (s *oraclePriceSource) Name() string { return s.name }
+
+// Uses the factomd set with factom.SetFactomdServer
+func (s *oraclePriceSource) Price() (PriceQuote, error) {
+	start := time.Now()
+	keyMR, err := factom.GetChainHead(s.chainID)
+	observeFactomd("chain-head", start)
+	if err != nil {
+		return PriceQuote{}, errors.New(fmt.Sprintf("Oracle chain %s: %s", s.chainID, err))
+	}
+
+	searched := 0
+	for keyMR != "" && keyMR != factom.ZeroHash && searched < s.maxBlocks {
+		start = time.Now()
+		eb, err := factom.GetEBlock(keyMR)
+		observeFactomd("entry-block", start)
+		if err != nil {
+			return PriceQuote{}, err
+		}
+		keyMR = eb.Header.PrevKeyMR
+		searched++
+		if s.height > 0 && eb.Header.DBHeight > s.height {
+			continue
+		}
+
+		prices, err := s.blockPrices(eb)
+		if err != nil {
+			return PriceQuote{}, err
+		}
+		if len(prices) >= s.minRecords {
+			return PriceQuote{Price: median(prices), Time: time.Unix(eb.Header.Timestamp, 0).UTC()}, nil
+		}
+	}
+	return PriceQuote{}, errors.New(fmt.Sprintf("No oracle block with %d signed price records in the last %d blocks", s.minRecords, searched))
+}
+
+// The price from each signer's last record in an entry block.  Records that
+// aren't signed by a signer for this block or don't decode are skipped.
+func (s *oraclePriceSource) blockPrices(eb *factom.EBlock) ([]float64, error) {
+	bySigner := make(map[string]float64)
+	for _, ebEntry := range eb.EntryList {
+		start := time.Now()
+		e, err := factom.GetEntry(ebEntry.EntryHash)
+		observeFactomd("entry", start)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := s.signer(e, eb.Header.DBHeight)
+		if !ok {
+			continue
+		}
+		if price, err := s.decoder.Decode(e); err == nil {
+			bySigner[signer] = price
+		}
+	}
+	prices := make([]float64, 0, len(bySigner))
+	for _, price := range bySigner {
+		prices = append(prices, price)
+	}
+	return prices, nil
+}
+
+// The signer of a record for the block at height: ExtID 0 is one of the
+// signers' public keys, ExtID 2 the height and ExtID 1 the signature of the
+// height and the content.
+func (s *oraclePriceSource) signer(e *factom.Entry, height int64) (string, bool) {
+	if len(e.ExtIDs) < 3 || len(e.ExtIDs[0]) != ed.PublicKeySize || len(e.ExtIDs[1]) != ed.SignatureSize {
+		return "", false
+	}
+	if string(e.ExtIDs[2]) != strconv.FormatInt(height, 10) {
+		return "", false
+	}
+	key := hex.EncodeToString(e.ExtIDs[0])
+	if !s.signers[key] {
+		return "", false
+	}
+	var pub [ed.PublicKeySize]byte
+	var sig [ed.SignatureSize]byte
+	copy(pub[:], e.ExtIDs[0])
+	copy(sig[:], e.ExtIDs[1])
+	signed := append(append([]byte{}, e.ExtIDs[2]...), e.Content...)
+	return key, ed.VerifyCanonical(&pub, signed, &sig)
+}
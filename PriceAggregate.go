@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// With several price sources the feed takes a robust aggregate of them:
+//  1. sources that fail, or whose quote is older than MaxAge or from more
+//     than maxQuoteClockSkew in the future, are dropped
+//  2. sources further than MaxDeviation from the median of the others are
+//     dropped as outliers
+//  3. fewer than Quorum sources left means no FER entry this pass
+//  4. the price is the median, or the trimmed mean dropping TrimFraction of
+//     the sources at each end
+// Every pass is written to DecisionLog as one JSON line with each source's
+// reading, so an auditor can see why a given TargetPrice was signed.
+
+const (
+	ReadingOK      = "ok"
+	ReadingError   = "error"
+	ReadingStale   = "stale"
+	ReadingOutlier = "outlier"
+)
+
+// What the price feed did in a pass
+const (
+	DecisionNoChange  = "no-change"
+	DecisionWaiting   = "waiting"
+	DecisionRefused   = "refused"
+	DecisionSubmitted = "submitted"
+	DecisionFailed    = "failed"
+)
+
+type SourceReading struct {
+	Name   string    `json:"name"`
+	Price  float64   `json:"price,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type PriceDecision struct {
+	Time              time.Time       `json:"time"`
+	Sources           []SourceReading `json:"sources"`
+	Aggregate         string          `json:"aggregate"`
+	Quorum            int             `json:"quorum"`
+	Agreeing          int             `json:"agreeing"`
+	Price             float64         `json:"price,omitempty"`
+	LeaderHeight      int64           `json:"leader-height,omitempty"`
+	CurrentRate       uint64          `json:"current-rate,omitempty"`
+	WantedTargetPrice uint64          `json:"wanted-target-price,omitempty"`
+	TargetPrice       uint64          `json:"target-price,omitempty"`
+	ActivationHeight  int64           `json:"activation-height,omitempty"`
+	Action            string          `json:"action"`
+	Reason            string          `json:"reason,omitempty"`
+	EntryHash         string          `json:"entry-hash,omitempty"`
+	TxID              string          `json:"txid,omitempty"`
+}
+
+type listDecisionsRequest struct {
+	Limit int `json:"limit"`
+}
+
+// Reads every source at once.  The readings come back in config order.
+func readPriceSources(sources []PriceSource) []SourceReading {
+	readings := make([]SourceReading, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source PriceSource) {
+			defer wg.Done()
+			r := SourceReading{Name: source.Name(), Status: ReadingOK}
+			quote, err := source.Price()
+			if err != nil {
+				r.Status = ReadingError
+				r.Error = err.Error()
+			} else {
+				r.Price = quote.Price
+				r.Time = quote.Time
+			}
+			readings[i] = r
+		}(i, source)
+	}
+	wg.Wait()
+	return readings
+}
+
+// A quote may be this far ahead of our clock before it counts as bad
+const maxQuoteClockSkew = time.Minute
+
+// aggregatePrices marks stale and outlying readings and returns the aggregate
+// of the rest, with how many sources agreed on it.
+func aggregatePrices(readings []SourceReading, feed PriceFeedConfig, quorum int, now time.Time) (float64, int, error) {
+	fresh := make([]int, 0, len(readings))
+	for i := range readings {
+		r := &readings[i]
+		if r.Status != ReadingOK {
+			continue
+		}
+		if ahead := r.Time.Sub(now); ahead > maxQuoteClockSkew {
+			r.Status = ReadingError
+			r.Error = fmt.Sprintf("Quote is %s in the future", ahead.Truncate(time.Second))
+			continue
+		}
+		if age := now.Sub(r.Time); age > feed.GetMaxAge() {
+			r.Status = ReadingStale
+			r.Error = fmt.Sprintf("Quote is %s old", age.Truncate(time.Second))
+			continue
+		}
+		fresh = append(fresh, i)
+	}
+	if len(fresh) == 0 {
+		return 0, 0, errors.New("No fresh price sources")
+	}
+
+	// Each source is checked against the median of the others, so an outlier
+	// doesn't pull the median it is measured against toward itself
+	agreeing := make([]float64, 0, len(fresh))
+	for _, i := range fresh {
+		others := make([]float64, 0, len(fresh)-1)
+		for _, j := range fresh {
+			if j != i {
+				others = append(others, readings[j].Price)
+			}
+		}
+		if len(others) > 0 {
+			mid := nearestMedian(others, readings[i].Price)
+			if deviation := math.Abs(readings[i].Price-mid) / mid; deviation > feed.GetMaxDeviation() {
+				readings[i].Status = ReadingOutlier
+				readings[i].Error = fmt.Sprintf("%.2f%% from the median of the others %g", deviation*100, mid)
+				continue
+			}
+		}
+		agreeing = append(agreeing, readings[i].Price)
+	}
+	if len(agreeing) < quorum {
+		return 0, len(agreeing), errors.New(fmt.Sprintf("Only %d of %d sources agree, quorum is %d", len(agreeing), len(readings), quorum))
+	}
+
+	if feed.GetAggregate() == "trimmed-mean" {
+		return trimmedMean(agreeing, feed.GetTrimFraction()), len(agreeing), nil
+	}
+	return median(agreeing), len(agreeing), nil
+}
+
+// With an even count every value between the middle two is a median; this
+// is the one nearest to v.  Taking the midpoint instead would let one outlier
+// among few sources move the median every other source is checked against.
+func nearestMedian(values []float64, v float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	low, high := sorted[(n-1)/2], sorted[n/2]
+	return math.Min(math.Max(v, low), high)
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// The mean after dropping trim of the values at each end
+func trimmedMean(values []float64, trim float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	drop := int(float64(len(sorted)) * trim)
+	kept := sorted[drop : len(sorted)-drop]
+	sum := 0.0
+	for _, v := range kept {
+		sum += v
+	}
+	return sum / float64(len(kept))
+}
+
+// Appends one decision to the log.  The file is only ever appended to.
+func appendPriceDecision(fileName string, d *PriceDecision) error {
+	return appendJSONLine(fileName, d)
+}
+
+// The last limit decisions, newest first.
+func readPriceDecisions(fileName string, limit int) ([]*PriceDecision, error) {
+	list := make([]*PriceDecision, 0)
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not open decision log %s: %s", fileName, err))
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		d := new(PriceDecision)
+		if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+			continue
+		}
+		list = append(list, d)
+		if limit > 0 && len(list) > limit {
+			list = list[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, nil
+}
+
+func handleListPriceDecisions(params []byte) (interface{}, *factom.JSONError) {
+	req := new(listDecisionsRequest)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, req); err != nil {
+			return nil, newInvalidParamsError()
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = 50
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	list, err := readPriceDecisions(config.PriceFeed.GetDecisionLog(), req.Limit)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return list, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAggregatePrices(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	type quote struct {
+		price float64
+		age   time.Duration
+		err   bool
+	}
+	for _, c := range []struct {
+		name      string
+		aggregate string
+		quorum    int
+		quotes    []quote
+		price     float64
+		agreeing  int
+		statuses  []string
+		fails     bool
+	}{
+		{
+			name:     "outlier dropped",
+			quorum:   2,
+			quotes:   []quote{{price: 1.00}, {price: 1.01}, {price: 0.99}, {price: 1.30}},
+			price:    1.00,
+			agreeing: 3,
+			statuses: []string{ReadingOK, ReadingOK, ReadingOK, ReadingOutlier},
+		},
+		{
+			name:     "stale source dropped",
+			quorum:   2,
+			quotes:   []quote{{price: 1.00}, {price: 1.02}, {price: 1.01, age: time.Hour}},
+			price:    1.01,
+			agreeing: 2,
+			statuses: []string{ReadingOK, ReadingOK, ReadingStale},
+		},
+		{
+			name:     "quote from the future dropped",
+			quorum:   2,
+			quotes:   []quote{{price: 1.00}, {price: 1.02}, {price: 1.01, age: -time.Hour}},
+			price:    1.01,
+			agreeing: 2,
+			statuses: []string{ReadingOK, ReadingOK, ReadingError},
+		},
+		{
+			name:     "quorum not met",
+			quorum:   2,
+			quotes:   []quote{{price: 1.00}, {err: true}, {price: 1.01, age: time.Hour}},
+			agreeing: 1,
+			statuses: []string{ReadingOK, ReadingError, ReadingStale},
+			fails:    true,
+		},
+		{
+			name:     "two sources that disagree",
+			quorum:   1,
+			quotes:   []quote{{price: 1.00}, {price: 1.20}},
+			statuses: []string{ReadingOutlier, ReadingOutlier},
+			fails:    true,
+		},
+		{
+			name:     "median",
+			quorum:   3,
+			quotes:   []quote{{price: 1.00}, {price: 1.00}, {price: 1.01}, {price: 1.04}, {price: 1.045}},
+			price:    1.01,
+			agreeing: 5,
+			statuses: []string{ReadingOK, ReadingOK, ReadingOK, ReadingOK, ReadingOK},
+		},
+		{
+			name:      "trimmed mean",
+			aggregate: "trimmed-mean",
+			quorum:    3,
+			quotes:    []quote{{price: 1.00}, {price: 1.00}, {price: 1.01}, {price: 1.04}, {price: 1.045}},
+			price:     (1.00 + 1.01 + 1.04) / 3,
+			agreeing:  5,
+			statuses:  []string{ReadingOK, ReadingOK, ReadingOK, ReadingOK, ReadingOK},
+		},
+		{
+			name:     "even number of sources",
+			quorum:   3,
+			quotes:   []quote{{price: 1.00}, {price: 1.02}, {price: 1.04}, {price: 1.06}},
+			price:    1.03,
+			agreeing: 4,
+			statuses: []string{ReadingOK, ReadingOK, ReadingOK, ReadingOK},
+		},
+		{
+			name:     "even number of sources with an outlier",
+			quorum:   3,
+			quotes:   []quote{{price: 1.00}, {price: 1.01}, {price: 1.02}, {price: 1.50}},
+			price:    1.01,
+			agreeing: 3,
+			statuses: []string{ReadingOK, ReadingOK, ReadingOK, ReadingOutlier},
+		},
+	} {
+		readings := make([]SourceReading, len(c.quotes))
+		for i, q := range c.quotes {
+			readings[i] = SourceReading{Name: string(rune('a' + i)), Price: q.price, Time: now.Add(-q.age), Status: ReadingOK}
+			if q.err {
+				readings[i] = SourceReading{Name: readings[i].Name, Status: ReadingError, Error: "unreachable"}
+			}
+		}
+		price, agreeing, err := aggregatePrices(readings, PriceFeedConfig{Aggregate: c.aggregate}, c.quorum, now)
+		if (err != nil) != c.fails {
+			t.Errorf("%s: error %v", c.name, err)
+		}
+		if !c.fails && math.Abs(price-c.price) > 1e-9 {
+			t.Errorf("%s: price is %g, want %g", c.name, price, c.price)
+		}
+		if agreeing != c.agreeing {
+			t.Errorf("%s: %d sources agree, want %d", c.name, agreeing, c.agreeing)
+		}
+		for i, r := range readings {
+			if r.Status != c.statuses[i] {
+				t.Errorf("%s: source %s is %s, want %s (%s)", c.name, r.Name, r.Status, c.statuses[i], r.Error)
+			}
+		}
+	}
+}
+
+type fixedPriceSource struct {
+	name  string
+	quote PriceQuote
+	err   error
+}
+
+func (s fixedPriceSource) Name() string               { return s.name }
+func (s fixedPriceSource) Price() (PriceQuote, error) { return s.quote, s.err }
+
+// Without a quorum the pass stops before anything is signed.
+func TestDecidePriceWithoutQuorum(t *testing.T) {
+	now := time.Now().UTC()
+	sources := []PriceSource{
+		fixedPriceSource{name: "a", quote: PriceQuote{Price: 1.00, Time: now}},
+		fixedPriceSource{name: "b", err: errors.New("unreachable")},
+		fixedPriceSource{name: "c", quote: PriceQuote{Price: 1.01, Time: now.Add(-time.Hour)}},
+	}
+	d := &PriceDecision{Time: now, Aggregate: "median", Quorum: 2}
+	if err := decidePrice(Config{}, sources, d); err == nil {
+		t.Fatal("No error without a quorum")
+	}
+	if d.Action != DecisionRefused || d.TargetPrice != 0 || d.EntryHash != "" {
+		t.Errorf("Decision is %s with target price %d and entry %q, want refused with no entry", d.Action, d.TargetPrice, d.EntryHash)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The price feed sets the FER from an FCT/USD price source instead of by hand.
+// Every Interval it reads the price, turns it into a TargetPrice with the
+// relation change-price reports (TargetPrice = 100000 / FCT price, an EC being
+// $0.001), and compares that with the current network rate:
+//   - a change smaller than Deadband (a fraction of the rate) is left alone
+//   - a change larger than MaxStep is cut down to MaxStep, so a bad quote can
+//     only move the rate so far and big moves take several steps
+//
+// A change goes out through CreateFEREntryAndReveal and SubmitFEREntry,
+// activating ActivationDelay blocks ahead.  No new change is made while a
+// submitted one is still waiting for its activation height.
+//
+// Source is a single source; Sources lists several, aggregated as described
+// in PriceAggregate.go.
+type PriceFeedConfig struct {
+	Source  PriceSourceConfig
+	Sources []PriceSourceConfig
+
+	Aggregate    string
+	TrimFraction float64
+	MaxDeviation float64
+	MaxAge       string
+	Quorum       int
+	DecisionLog  string
+
+	Interval        string
+	Deadband        float64
+	MaxStep         float64
+	ActivationDelay int
+	Priority        int
+}
+
+func (c PriceFeedConfig) enabled() bool {
+	return len(c.sourceConfigs()) > 0
+}
+
+func (c PriceFeedConfig) sourceConfigs() []PriceSourceConfig {
+	sources := c.Sources
+	if c.Source.Type != "" {
+		sources = append([]PriceSourceConfig{c.Source}, sources...)
+	}
+	return sources
+}
+
+// "median" or "trimmed-mean"
+func (c PriceFeedConfig) GetAggregate() string {
+	if c.Aggregate == "" {
+		return "median"
+	}
+	return c.Aggregate
+}
+
+func (c PriceFeedConfig) GetTrimFraction() float64 {
+	if c.TrimFraction <= 0 {
+		return 0.2
+	}
+	return math.Min(c.TrimFraction, 0.49)
+}
+
+func (c PriceFeedConfig) GetMaxDeviation() float64 {
+	if c.MaxDeviation <= 0 {
+		return 0.05
+	}
+	return c.MaxDeviation
+}
+
+func (c PriceFeedConfig) GetMaxAge() time.Duration {
+	d, err := time.ParseDuration(c.MaxAge)
+	if err != nil || d <= 0 {
+		return 15 * time.Minute
+	}
+	return d
+}
+
+// A majority of the sources unless set
+func (c PriceFeedConfig) GetQuorum() int {
+	if c.Quorum < 1 {
+		return len(c.sourceConfigs())/2 + 1
+	}
+	return c.Quorum
+}
+
+func (c PriceFeedConfig) GetDecisionLog() string {
+	if c.DecisionLog == "" {
+		return "FERPriceDecisions.log"
+	}
+	return c.DecisionLog
+}
+
+func (c PriceFeedConfig) GetInterval() time.Duration {
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d <= 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+func (c PriceFeedConfig) GetDeadband() float64 {
+	if c.Deadband <= 0 {
+		return 0.01
+	}
+	return c.Deadband
+}
+
+func (c PriceFeedConfig) GetMaxStep() float64 {
+	if c.MaxStep <= 0 {
+		return 0.1
+	}
+	return c.MaxStep
+}
+
+func (c PriceFeedConfig) GetActivationDelay() int64 {
+	if c.ActivationDelay < 2 {
+		return 6
+	}
+	return int64(c.ActivationDelay)
+}
+
+func (c PriceFeedConfig) GetPriority() int {
+	if c.Priority < 1 {
+		return 1
+	}
+	return c.Priority
+}
+
+// Held for a whole pass, so main can wait for a submission to finish.
+var priceFeedLock sync.Mutex
+
+// The FER TargetPrice, factoshis per EC, for an FCT/USD price
+func targetPriceForUSD(price float64) uint64 {
+	target := math.Round(100000 / price)
+	if target < 1 {
+		return 1
+	}
+	return uint64(target)
+}
+
+// Applies the deadband and step limit to a wanted TargetPrice.  Returns false
+// when the rate should stay as it is.
+func limitTargetPrice(current uint64, wanted uint64, deadband float64, maxStep float64) (uint64, bool) {
+	if current == 0 {
+		return wanted, true
+	}
+	change := (float64(wanted) - float64(current)) / float64(current)
+	if math.Abs(change) < deadband {
+		return current, false
+	}
+	if change > maxStep {
+		return uint64(math.Round(float64(current) * (1 + maxStep))), true
+	}
+	if change < -maxStep {
+		return uint64(math.Max(1, math.Round(float64(current)*(1-maxStep)))), true
+	}
+	return wanted, true
+}
+
+// Runs the price feed forever.  The interval is reread from the config every
+// pass.
+func runPriceFeed() {
+	for {
+		interval := 10 * time.Minute
+		if config, err := readConfigFile(configFileName); err == nil && config.PriceFeed.enabled() {
+			interval = config.PriceFeed.GetInterval()
+			if err := runPriceFeedPass(config); err != nil {
+				logger.Warn("Price feed pass failed", "component", "price-feed", "error", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func runPriceFeedPass(config Config) error {
+	priceFeedLock.Lock()
+	defer priceFeedLock.Unlock()
+
+	feed := config.PriceFeed
+	if config.ApprovalsRequired > 0 {
+		err := errors.New("FER changes require approval, the price feed can't sign them")
+		publishPolicyRejection("price-feed", err.Error())
+		return err
+	}
+	if feed.GetAggregate() != "median" && feed.GetAggregate() != "trimmed-mean" {
+		return errors.New(fmt.Sprintf("Unknown price feed aggregate: %s", feed.Aggregate))
+	}
+	sources := make([]PriceSource, 0)
+	for _, c := range feed.sourceConfigs() {
+		source, err := newPriceSource(c)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	d := &PriceDecision{Time: time.Now().UTC(), Aggregate: feed.GetAggregate(), Quorum: feed.GetQuorum()}
+	err := decidePrice(config, sources, d)
+	if err != nil && d.Action == "" {
+		d.Action = DecisionFailed
+	}
+	if err != nil && d.Reason == "" {
+		d.Reason = err.Error()
+	}
+	if logErr := appendPriceDecision(feed.GetDecisionLog(), d); logErr != nil {
+		logger.Error("Could not write price decision", "component", "price-feed", "error", logErr)
+	}
+	return err
+}
+
+// decidePrice fills in d as it goes, so the decision log shows how far a pass
+// got when it stops.
+func decidePrice(config Config, sources []PriceSource, d *PriceDecision) error {
+	log := logger.With("component", "price-feed")
+	feed := config.PriceFeed
+
+	// Oracle chain sources read from the same factomd
+	factom.SetFactomdServer(config.GetFactomdServer())
+	d.Sources = readPriceSources(sources)
+	price, agreeing, err := aggregatePrices(d.Sources, feed, d.Quorum, d.Time)
+	d.Agreeing = agreeing
+	if err != nil {
+		d.Action = DecisionRefused
+		publishPolicyRejection("price-feed", err.Error())
+		return err
+	}
+	d.Price = price
+	metricFeedPrice.set(price)
+
+	start := time.Now()
+	heights, err := factom.GetHeights()
+	observeFactomd("heights", start)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not read heights: %s", err))
+	}
+	d.LeaderHeight = heights.LeaderHeight
+	start = time.Now()
+	rate, err := factom.GetRate()
+	observeFactomd("entry-credit-rate", start)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not read the EC rate: %s", err))
+	}
+	d.CurrentRate = rate
+
+	if pending, err := pendingSubmittedEntries(heights.LeaderHeight); err != nil {
+		return err
+	} else if pending > 0 {
+		d.Action = DecisionWaiting
+		d.Reason = fmt.Sprintf("%d submitted change(s) not yet active", pending)
+		return nil
+	}
+
+	d.WantedTargetPrice = targetPriceForUSD(price)
+	target, change := limitTargetPrice(rate, d.WantedTargetPrice, feed.GetDeadband(), feed.GetMaxStep())
+	log.Info("Price feed decision", "fct_usd", price, "agreeing", agreeing, "current_rate", rate,
+		"wanted_target_price", d.WantedTargetPrice, "target_price", target, "change", change)
+	if !change {
+		d.Action = DecisionNoChange
+		return nil
+	}
+	d.TargetPrice = target
+
+	d.ActivationHeight = heights.LeaderHeight + feed.GetActivationDelay()
+	entry, reveal, _, _, err := CreateFEREntryAndReveal(strconv.FormatInt(d.ActivationHeight-1, 10), strconv.FormatInt(d.ActivationHeight, 10),
+		strconv.Itoa(feed.GetPriority()), strconv.FormatUint(target, 10))
+	if err != nil {
+		return err
+	}
+	metricComposed.inc("price-feed")
+	d.EntryHash = entryHashFromReveal(reveal)
+	d.TxID, _, err = SubmitFEREntry(config.GetFactomdServer(), entry, reveal)
+	if err != nil {
+		return err
+	}
+	metricSubmitted.inc("price-feed")
+	d.Action = DecisionSubmitted
+	log.Info("Price feed change submitted", "target_price", target, "activation_height", d.ActivationHeight, "entry_hash", d.EntryHash, "txid", d.TxID)
+	return nil
+}
+
+// Submitted entries in the entry store whose activation height is ahead
+func pendingSubmittedEntries(leaderHeight int64) (int, error) {
+	if entryStore == nil {
+		return 0, nil
+	}
+	list, err := entryStore.List(EntryFilter{FromActivationHeight: uint32(leaderHeight + 1)})
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, e := range list {
+		if e.inFlight() {
+			pending++
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A PriceSource gives the current FCT/USD price.
+type PriceSource interface {
+	Name() string
+	Price() (PriceQuote, error)
+}
+
+type PriceQuote struct {
+	Price float64   `json:"price"`
+	Time  time.Time `json:"time"`
+}
+
+// One price source in the config file.
+//
+//	Type = "file"    - File holds the price
+//	Type = "http"    - GET URL
+//	Type = "command" - run Command and read its stdout
+//	Type = "oracle-chain" - oracle records on a Factom chain, see OracleSource.go
+//
+// The data is either a bare number or JSON, with Path pointing at the price
+// ("data.FCT.quote.USD.price", numbers index arrays).  TimePath optionally
+// points at the time of the quote; without it a quote is as old as the file,
+// or read just now.
+type PriceSourceConfig struct {
+	Name     string
+	Type     string
+	File     string
+	URL      string
+	Command  []string
+	Path     string
+	TimePath string
+	Timeout  string
+
+	// oracle-chain
+	ChainID    string
+	Decoder    string
+	Height     int64
+	MinRecords int
+	MaxBlocks  int
+	Signers    []string
+}
+
+func newPriceSource(c PriceSourceConfig) (PriceSource, error) {
+	timeout := 10 * time.Second
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid price source timeout: %s", c.Timeout))
+		}
+		timeout = d
+	}
+	name := c.Name
+	if name == "" {
+		name = c.Type
+	}
+
+	switch c.Type {
+	case "file":
+		if c.File == "" {
+			return nil, errors.New("File price source needs a File")
+		}
+		return &filePriceSource{name: name, file: c.File, path: c.Path, timePath: c.TimePath}, nil
+	case "http":
+		if c.URL == "" {
+			return nil, errors.New("HTTP price source needs a URL")
+		}
+		return &httpPriceSource{name: name, url: c.URL, path: c.Path, timePath: c.TimePath, client: &http.Client{Timeout: timeout}}, nil
+	case "command":
+		if len(c.Command) == 0 {
+			return nil, errors.New("Command price source needs a Command")
+		}
+		return &commandPriceSource{name: name, command: c.Command, path: c.Path, timePath: c.TimePath, timeout: timeout}, nil
+	case "oracle-chain":
+		return newOraclePriceSource(name, c)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown price source type: %s", c.Type))
+}
+
+// The quote time of a file is its modification time
+type filePriceSource struct {
+	name, file, path, timePath string
+}
+
+func (s *filePriceSource) Name() string { return s.name }
+
+func (s *filePriceSource) Price() (PriceQuote, error) {
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return PriceQuote{}, err
+	}
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return PriceQuote{}, err
+	}
+	return parseQuote(data, s.path, s.timePath, fi.ModTime().UTC())
+}
+
+type httpPriceSource struct {
+	name, url, path, timePath string
+	client                    *http.Client
+}
+
+func (s *httpPriceSource) Name() string { return s.name }
+
+func (s *httpPriceSource) Price() (PriceQuote, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return PriceQuote{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PriceQuote{}, errors.New(fmt.Sprintf("%s returned %s", s.url, resp.Status))
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return PriceQuote{}, err
+	}
+	return parseQuote(data, s.path, s.timePath, time.Now().UTC())
+}
+
+type commandPriceSource struct {
+	name     string
+	command  []string
+	path     string
+	timePath string
+	timeout  time.Duration
+}
+
+func (s *commandPriceSource) Name() string { return s.name }
+
+func (s *commandPriceSource) Price() (PriceQuote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return PriceQuote{}, errors.New(fmt.Sprintf("Price command %s failed: %s %s", s.command[0], err, stderr.String()))
+	}
+	return parseQuote(stdout.Bytes(), s.path, s.timePath, time.Now().UTC())
+}
+
+// parseQuote reads a positive price from a bare number, or from JSON at path.
+// With timePath the quote time comes from the JSON too, as unix seconds or
+// RFC 3339; otherwise it is t.
+func parseQuote(data []byte, path string, timePath string, t time.Time) (PriceQuote, error) {
+	if path == "" {
+		price, err := positivePrice(strings.TrimSpace(string(data)))
+		return PriceQuote{Price: price, Time: t}, err
+	}
+
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return PriceQuote{}, errors.New(fmt.Sprintf("Price source did not return JSON: %s", err))
+	}
+
+	value, ok := jsonPathString(doc, path)
+	if !ok {
+		return PriceQuote{}, errors.New(fmt.Sprintf("No price at %s in price source JSON", path))
+	}
+	price, err := positivePrice(value)
+	if err != nil {
+		return PriceQuote{}, err
+	}
+
+	if timePath != "" {
+		value, ok := jsonPathString(doc, timePath)
+		if !ok {
+			return PriceQuote{}, errors.New(fmt.Sprintf("No time at %s in price source JSON", timePath))
+		}
+		if t, err = parseQuoteTime(value); err != nil {
+			return PriceQuote{}, err
+		}
+	}
+	return PriceQuote{Price: price, Time: t}, nil
+}
+
+// The number or string at a dotted path; numbers in the path index arrays.
+func jsonPathString(v interface{}, path string) (string, bool) {
+	for _, part := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[part]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch value := v.(type) {
+	case json.Number:
+		return value.String(), true
+	case string:
+		return value, true
+	}
+	return "", false
+}
+
+func parseQuoteTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(seconds*1e9)).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("Invalid quote time: %.40s", s))
+	}
+	return t.UTC(), nil
+}
+
+func positivePrice(s string) (float64, error) {
+	price, err := strconv.ParseFloat(s, 64)
+	if err != nil || price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+		return 0, errors.New(fmt.Sprintf("Invalid price: %.40s", s))
+	}
+	return price, nil
+}
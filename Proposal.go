@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A proposal holds an FER change until ApprovalsRequired of the configured
+// Approvers have signed off on it.  Only then is the FEREntry signed with the
+// SigningPrivateKey and composed (and submitted, if asked for).
+//
+// Approvers sign the message "<method>:<proposal id>" with their ed25519 key,
+// where method is approve-proposal or reject-proposal.  The proposal id is a
+// hash of the proposal content, so a vote can't be moved to another proposal.
+
+const (
+	ProposalPending  = "pending"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+	ProposalExpired  = "expired"
+	ProposalFailed   = "failed"
+)
+
+type ProposalVote struct {
+	Approver  string    `json:"approver"`
+	Signature string    `json:"signature"`
+	Time      time.Time `json:"time"`
+}
+
+type Proposal struct {
+	ID string `json:"id"`
+	ChangeResponse
+	Reason     string           `json:"reason"`
+	Submit     bool             `json:"submit"`
+	Status     string           `json:"status"`
+	Approvals  []ProposalVote   `json:"approvals"`
+	Rejections []ProposalVote   `json:"rejections"`
+	CreatedAt  time.Time        `json:"created-at"`
+	ExpiresAt  time.Time        `json:"expires-at"`
+	Result     *addressResponse `json:"result,omitempty"`
+	TxID       string           `json:"txid,omitempty"`
+	EntryHash  string           `json:"entry-hash,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type proposeRequest struct {
+	ChangeResponse
+	Reason string `json:"reason"`
+	Submit bool   `json:"submit"`
+}
+
+type voteRequest struct {
+	ProposalID string `json:"proposal-id"`
+	Approver   string `json:"approver"`
+	Signature  string `json:"signature"`
+}
+
+type listProposalsRequest struct {
+	Status string `json:"status"`
+}
+
+// Guards the proposal file.  Every change is a load, modify, save under this lock.
+var proposalLock sync.Mutex
+
+func ProposalVoteMessage(method string, proposalID string) []byte {
+	return []byte(method + ":" + proposalID)
+}
+
+func newProposalID(p *Proposal) string {
+	content, _ := json.Marshal(struct {
+		ChangeResponse
+		Reason    string
+		Submit    bool
+		CreatedAt int64
+	}{p.ChangeResponse, p.Reason, p.Submit, p.CreatedAt.UnixNano()})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Moves a pending proposal past its expiry time to expired.
+func (p *Proposal) refreshStatus(now time.Time) {
+	if p.Status == ProposalPending && now.After(p.ExpiresAt) {
+		p.Status = ProposalExpired
+	}
+}
+
+func (p *Proposal) hasVoted(approver string) bool {
+	for _, v := range p.Approvals {
+		if v.Approver == approver {
+			return true
+		}
+	}
+	for _, v := range p.Rejections {
+		if v.Approver == approver {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the change as the FEREntry the final approval would sign, so a
+// proposal that doesn't fit the schema of the config's Version is refused now
+// rather than once approved.
+func validateChangeParams(config Config, c ChangeResponse) error {
+	if _, err := strconv.ParseUint(c.ExpirationHeight, 10, 32); err != nil {
+		return errors.New(fmt.Sprintf("Invalid expiration-height: %s", c.ExpirationHeight))
+	}
+	if _, err := strconv.ParseUint(c.ActivationHeight, 10, 32); err != nil {
+		return errors.New(fmt.Sprintf("Invalid activation-height: %s", c.ActivationHeight))
+	}
+	if _, err := strconv.ParseUint(c.Priority, 10, 32); err != nil {
+		return errors.New(fmt.Sprintf("Invalid priority: %s", c.Priority))
+	}
+	price, err := strconv.ParseUint(c.NewPricePerEC, 10, 64)
+	if err != nil || price == 0 {
+		return errors.New(fmt.Sprintf("Invalid new-price-per-EC: %s", c.NewPricePerEC))
+	}
+	_, err = NewFEREntry(config.Version, c.ExpirationHeight, c.ActivationHeight, c.Priority, c.NewPricePerEC, c.FERMetadata)
+	return err
+}
+
+func loadProposals(fileName string) (map[string]*Proposal, error) {
+	proposals := make(map[string]*Proposal)
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return proposals, nil
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read proposal file %s: %s", fileName, err))
+	}
+	if err := json.Unmarshal(data, &proposals); err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not parse proposal file %s: %s", fileName, err))
+	}
+	return proposals, nil
+}
+
+// Writes to a temp file and renames it over the old one, so a crash never
+// leaves a half written proposal file behind.
+func saveProposals(fileName string, proposals map[string]*Proposal) error {
+	data, err := json.MarshalIndent(proposals, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.New(fmt.Sprintf("Could not write proposal file %s: %s", tmp, err))
+	}
+	return os.Rename(tmp, fileName)
+}
+
+func CreateProposal(config Config, change ChangeResponse, reason string, submit bool) (*Proposal, error) {
+	if config.ApprovalsRequired < 1 {
+		return nil, errors.New("Proposals are disabled, set ApprovalsRequired in the config file")
+	}
+	if err := validateChangeParams(config, change); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, errors.New("A proposal needs a reason")
+	}
+
+	proposalLock.Lock()
+	defer proposalLock.Unlock()
+
+	proposals, err := loadProposals(config.GetProposalFile())
+	if err != nil {
+		return nil, err
+	}
+
+	p := new(Proposal)
+	p.ChangeResponse = change
+	p.Reason = reason
+	p.Submit = submit
+	p.Status = ProposalPending
+	p.Approvals = []ProposalVote{}
+	p.Rejections = []ProposalVote{}
+	p.CreatedAt = time.Now().UTC()
+	p.ExpiresAt = p.CreatedAt.Add(config.GetProposalLifetime())
+	p.ID = newProposalID(p)
+	proposals[p.ID] = p
+
+	if err := saveProposals(config.GetProposalFile(), proposals); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// VoteProposal records an approval or rejection.  The approval that brings a
+// proposal to ApprovalsRequired signs and composes the FER entry.
+func VoteProposal(config Config, method string, vote voteRequest) (*Proposal, error) {
+	approver := strings.ToLower(vote.Approver)
+	if !isConfiguredApprover(config, approver) {
+		return nil, errors.New("Not a configured approver")
+	}
+	if err := verifyVote(approver, vote.Signature, ProposalVoteMessage(method, vote.ProposalID)); err != nil {
+		return nil, err
+	}
+
+	proposalLock.Lock()
+	defer proposalLock.Unlock()
+
+	proposals, err := loadProposals(config.GetProposalFile())
+	if err != nil {
+		return nil, err
+	}
+	p, ok := proposals[vote.ProposalID]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No proposal with id %s", vote.ProposalID))
+	}
+
+	now := time.Now().UTC()
+	p.refreshStatus(now)
+	if p.Status != ProposalPending {
+		saveProposals(config.GetProposalFile(), proposals)
+		return nil, errors.New(fmt.Sprintf("Proposal is %s", p.Status))
+	}
+	if p.hasVoted(approver) {
+		return nil, errors.New("Approver already voted on this proposal")
+	}
+
+	v := ProposalVote{Approver: approver, Signature: strings.ToLower(vote.Signature), Time: now}
+	if method == "approve-proposal" {
+		p.Approvals = append(p.Approvals, v)
+		if len(p.Approvals) >= config.ApprovalsRequired {
+			executeProposal(config, p)
+		}
+	} else {
+		p.Rejections = append(p.Rejections, v)
+		// Rejected once there are no longer enough approvers left to pass it
+		if len(config.Approvers)-len(p.Rejections) < config.ApprovalsRequired {
+			p.Status = ProposalRejected
+			publishPolicyRejection("proposal", fmt.Sprintf("Proposal %s rejected by %d approvers", p.ID, len(p.Rejections)))
+		}
+	}
+
+	if err := saveProposals(config.GetProposalFile(), proposals); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func executeProposal(config Config, p *Proposal) {
+	// A 2.0 entry names the proposal it came from
+	meta := p.FERMetadata
+	if config.Version == FERVersion2 && meta.ProposalRef == "" {
+		meta.ProposalRef = p.ID
+	}
+	entry, reveal, targetPriceInDollars, ecAddress, err := CreateFEREntryAndRevealAt(p.ExpirationHeight, p.ActivationHeight, p.Priority, p.NewPricePerEC, meta, time.Time{})
+	if err != nil {
+		p.Status = ProposalFailed
+		p.Error = err.Error()
+		return
+	}
+	p.Result = &addressResponse{EntryCommitJson: entry, RevealJson: reveal, TargetPriceInDollars: targetPriceInDollars, ECAddress: ecAddress}
+	p.Status = ProposalApproved
+	metricComposed.inc("approve-proposal")
+	recordEntryProposal(reveal, p.ID)
+
+	if p.Submit {
+		p.TxID, p.EntryHash, err = SubmitFEREntry(config.GetFactomdServer(), entry, reveal)
+		if err != nil {
+			p.Status = ProposalFailed
+			p.Error = err.Error()
+			return
+		}
+		metricSubmitted.inc("approve-proposal")
+	}
+}
+
+func ListProposals(config Config, status string) ([]*Proposal, error) {
+	proposalLock.Lock()
+	defer proposalLock.Unlock()
+
+	proposals, err := loadProposals(config.GetProposalFile())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	list := make([]*Proposal, 0, len(proposals))
+	for _, p := range proposals {
+		p.refreshStatus(now)
+		if status == "" || p.Status == status {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func isConfiguredApprover(config Config, approver string) bool {
+	for _, a := range config.Approvers {
+		if strings.ToLower(a) == approver {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyVote(approver string, signature string, message []byte) error {
+	var pub [ed.PublicKeySize]byte
+	var sig [ed.SignatureSize]byte
+
+	pubBytes, err := hex.DecodeString(approver)
+	if err != nil || len(pubBytes) != ed.PublicKeySize {
+		return errors.New("Approver must be a hex ed25519 public key")
+	}
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil || len(sigBytes) != ed.SignatureSize {
+		return errors.New("Signature must be a hex ed25519 signature")
+	}
+	copy(pub[:], pubBytes)
+	copy(sig[:], sigBytes)
+
+	if !ed.VerifyCanonical(&pub, message, &sig) {
+		return errors.New("Signature does not verify for this approver and proposal")
+	}
+	return nil
+}
+
+func handleProposeFERChange(params []byte) (interface{}, *factom.JSONError) {
+	req := new(proposeRequest)
+	if err := json.Unmarshal(params, req); err != nil {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	p, err := CreateProposal(config, req.ChangeResponse, req.Reason, req.Submit)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return p, nil
+}
+
+func handleVoteProposal(method string, params []byte) (interface{}, *factom.JSONError) {
+	req := new(voteRequest)
+	if err := json.Unmarshal(params, req); err != nil {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	p, err := VoteProposal(config, method, *req)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return p, nil
+}
+
+func handleListProposals(params []byte) (interface{}, *factom.JSONError) {
+	req := new(listProposalsRequest)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, req); err != nil {
+			return nil, newInvalidParamsError()
+		}
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	list, err := ListProposals(config, req.Status)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return list, nil
+}
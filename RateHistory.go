@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The exchange rate history comes from the factoid blocks, which record the
+// rate they were built with (exchrate).  The series is collapsed to the heights
+// where it changed, and each change is matched to the FER chain entry that
+// asked for it: the one with that TargetPrice whose activation height is the
+// latest at or within rateCauseWindow blocks before the change, highest
+// Priority first.  When the config has a signing key, entries not signed by it
+// are ignored, as factomd ignores them.
+
+// The most factoid blocks read for one history over the API.  Each is a
+// factomd call, so this many fit well within the default -write-timeout.
+const maxRateHistoryBlocks = 500
+
+// The default -max-blocks of "fer-api rate-history"
+const maxRateHistoryCommandBlocks = 100000
+
+// How far before a change its FER entry's activation height may be
+const rateCauseWindow = 10
+
+type RateChange struct {
+	Height       int64      `json:"height"`
+	Rate         uint64     `json:"rate"`
+	PreviousRate uint64     `json:"previous-rate,omitempty"`
+	Cause        *RateCause `json:"cause,omitempty"`
+}
+
+// The FER entry a change is put down to
+type RateCause struct {
+	EntryHash   string   `json:"entry-hash"`
+	EntryHeight int64    `json:"entry-height"`
+	FEREntry    FEREntry `json:"fer-entry"`
+	// Why the content doesn't fit the schema of its version.  factomd still
+	// takes such an entry, so it can still be the cause.
+	SchemaError string `json:"schema-error,omitempty"`
+}
+
+type RateHistory struct {
+	FromHeight int64         `json:"from-height"`
+	ToHeight   int64         `json:"to-height"`
+	Changes    []*RateChange `json:"changes"`
+}
+
+type rateHistoryRequest struct {
+	FromHeight int64  `json:"from-height"`
+	ToHeight   int64  `json:"to-height"`
+	Format     string `json:"format"`
+}
+
+// The rate recorded in the factoid block at height
+func fblockRate(height int64) (uint64, error) {
+	start := time.Now()
+	block, err := factom.GetFBlockByHeight(height)
+	observeFactomd("fblock-by-height", start)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Could not get factoid block %d: %s", height, err))
+	}
+	rate, ok := block.FBlock["exchrate"].(float64)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("Factoid block %d has no exchange rate", height))
+	}
+	return uint64(rate), nil
+}
+
+// getRateHistory reads the factoid blocks from..to, with to defaulting to the
+// directory block height.  maxBlocks of 0 reads any number.
+func getRateHistory(config Config, from int64, to int64, maxBlocks int64) (*RateHistory, error) {
+	factom.SetFactomdServer(config.GetFactomdServer())
+	if to <= 0 {
+		start := time.Now()
+		heights, err := factom.GetHeights()
+		observeFactomd("heights", start)
+		if err != nil {
+			return nil, err
+		}
+		to = heights.DirectoryBlockHeight
+	}
+	if from < 0 || from > to {
+		return nil, errors.New(fmt.Sprintf("Bad height range %d to %d", from, to))
+	}
+	if maxBlocks > 0 && to-from >= maxBlocks {
+		return nil, errors.New(fmt.Sprintf("A history covers at most %d blocks, use fer-api rate-history -max-blocks for more", maxBlocks))
+	}
+
+	history := &RateHistory{FromHeight: from, ToHeight: to, Changes: make([]*RateChange, 0)}
+	var last *RateChange
+	for height := from; height <= to; height++ {
+		rate, err := fblockRate(height)
+		if err != nil {
+			return nil, err
+		}
+		if last != nil && rate == last.Rate {
+			continue
+		}
+		change := &RateChange{Height: height, Rate: rate}
+		if last != nil {
+			change.PreviousRate = last.Rate
+		}
+		history.Changes = append(history.Changes, change)
+		last = change
+	}
+
+	// The first point is the rate the range starts with, not a change
+	if len(history.Changes) > 1 {
+		causes, err := ferChainEntriesSince(config, from-rateCauseWindow)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range history.Changes[1:] {
+			change.Cause = rateChangeCause(change, causes)
+		}
+	}
+	return history, nil
+}
+
+// The FER chain entries in entry blocks from height on.  The FER chain is
+// small, so it is walked back from its head.
+func ferChainEntriesSince(config Config, height int64) ([]*RateCause, error) {
+	var publicKey *[ed.PublicKeySize]byte
+	if key, err := config.GetSigningPublicKey(); err == nil {
+		publicKey = key
+	}
+
+	start := time.Now()
+	keyMR, err := factom.GetChainHead(FERChainID)
+	observeFactomd("chain-head", start)
+	if err != nil {
+		return nil, err
+	}
+	causes := make([]*RateCause, 0)
+	for keyMR != factom.ZeroHash {
+		start = time.Now()
+		eb, err := factom.GetEBlock(keyMR)
+		observeFactomd("entry-block", start)
+		if err != nil {
+			return nil, err
+		}
+		for _, ebEntry := range eb.EntryList {
+			start = time.Now()
+			e, err := factom.GetEntry(ebEntry.EntryHash)
+			observeFactomd("entry", start)
+			if err != nil {
+				return nil, err
+			}
+			cause := &RateCause{EntryHash: ebEntry.EntryHash, EntryHeight: eb.Header.DBHeight}
+			fer, err := readChainFEREntry(e.Content)
+			if err != nil {
+				continue
+			}
+			cause.FEREntry = *fer
+			if _, err := decodeFEREntry(e.Content); err != nil {
+				cause.SchemaError = err.Error()
+			}
+			if publicKey != nil && !signedBy(publicKey, e) {
+				continue
+			}
+			causes = append(causes, cause)
+		}
+		if eb.Header.DBHeight < height {
+			break
+		}
+		keyMR = eb.Header.PrevKeyMR
+	}
+	return causes, nil
+}
+
+func signedBy(publicKey *[ed.PublicKeySize]byte, e *factom.Entry) bool {
+	if len(e.ExtIDs) == 0 || len(e.ExtIDs[0]) != ed.SignatureSize {
+		return false
+	}
+	var sig [ed.SignatureSize]byte
+	copy(sig[:], e.ExtIDs[0])
+	return ed.Verify(publicKey, e.Content, &sig)
+}
+
+func rateChangeCause(change *RateChange, causes []*RateCause) *RateCause {
+	var best *RateCause
+	for _, c := range causes {
+		activation := int64(c.FEREntry.TargetActivationHeight)
+		if c.FEREntry.TargetPrice != change.Rate || activation > change.Height || activation < change.Height-rateCauseWindow {
+			continue
+		}
+		if c.EntryHeight > int64(c.FEREntry.ExpirationHeight) {
+			continue
+		}
+		if best == nil || activation > int64(best.FEREntry.TargetActivationHeight) ||
+			(activation == int64(best.FEREntry.TargetActivationHeight) && c.FEREntry.Priority > best.FEREntry.Priority) {
+			best = c
+		}
+	}
+	return best
+}
+
+func (h *RateHistory) writeCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"height", "rate", "previous-rate", "entry-hash", "entry-height", "target-activation-height", "priority", "schema-error"})
+	for _, c := range h.Changes {
+		row := []string{strconv.FormatInt(c.Height, 10), strconv.FormatUint(c.Rate, 10), "", "", "", "", "", ""}
+		if c.PreviousRate != 0 {
+			row[2] = strconv.FormatUint(c.PreviousRate, 10)
+		}
+		if c.Cause != nil {
+			row[3] = c.Cause.EntryHash
+			row[4] = strconv.FormatInt(c.Cause.EntryHeight, 10)
+			row[5] = strconv.FormatUint(uint64(c.Cause.FEREntry.TargetActivationHeight), 10)
+			row[6] = strconv.FormatUint(uint64(c.Cause.FEREntry.Priority), 10)
+			row[7] = c.Cause.SchemaError
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// get-rate-history returns the RateHistory, or with "format": "csv" the same
+// as a CSV string.
+func handleGetRateHistory(params []byte) (interface{}, *factom.JSONError) {
+	req := new(rateHistoryRequest)
+	if err := json.Unmarshal(params, req); err != nil {
+		return nil, newInvalidParamsError()
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "csv" {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	history, err := getRateHistory(config, req.FromHeight, req.ToHeight, maxRateHistoryBlocks)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	if req.Format == "csv" {
+		var buf bytes.Buffer
+		if err := history.writeCSV(&buf); err != nil {
+			return nil, newCustomInternalError(err.Error())
+		}
+		return buf.String(), nil
+	}
+	return history, nil
+}
+
+// "fer-api rate-history" writes the history without a running server
+func runRateHistoryCommand(args []string) error {
+	flags := flag.NewFlagSet("rate-history", flag.ExitOnError)
+	conf := flags.String("config", configFileName, "config file naming factomd")
+	from := flags.Int64("from", 0, "first block height")
+	to := flags.Int64("to", 0, "last block height (default the directory block height)")
+	format := flags.String("format", "csv", "csv or json")
+	out := flags.String("out", "", "file to write (default stdout)")
+	maxBlocks := flags.Int64("max-blocks", maxRateHistoryCommandBlocks, "most blocks read, 0 for no limit")
+	flags.Parse(args)
+	if *format != "csv" && *format != "json" {
+		return errors.New(fmt.Sprintf("Unknown format %s", *format))
+	}
+
+	config, err := readConfigFile(*conf)
+	if err != nil {
+		return err
+	}
+	history, err := getRateHistory(config, *from, *to, *maxBlocks)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not create %s: %s", *out, err))
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		return history.writeCSV(w)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(history)
+}
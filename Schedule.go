@@ -0,0 +1,505 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A scheduled change is registered now and composed, signed and submitted by
+// the scheduler once the leader height is within ScheduleLeadBlocks of its
+// activation height.  The change is given either as an activation height or as
+// a wall clock time, which is turned into a height when the change goes out.
+//
+// The entry gets ExpirationHeight = activation height - 1, so it has to make
+// it into a block before the new price applies.  A change whose activation
+// height has already passed when the scheduler gets to it (fer-api was down)
+// is marked missed instead of going out late.
+
+// factomd drops an FER entry whose expiration height is more than this many
+// blocks past the leader height.  With expiration = activation - 1 that caps
+// ScheduleLeadBlocks at maxExpirationAhead + 1.
+const maxExpirationAhead = 12
+
+const (
+	ScheduleScheduled  = "scheduled"
+	ScheduleSubmitting = "submitting"
+	ScheduleSubmitted  = "submitted"
+	ScheduleFailed     = "failed"
+	ScheduleMissed     = "missed"
+	ScheduleCancelled  = "cancelled"
+)
+
+// Average block time, used to turn an activate-at time into a height
+const blockTime = 10 * time.Minute
+
+type ScheduledChange struct {
+	ID               string           `json:"id"`
+	ActivationHeight uint32           `json:"activation-height,omitempty"`
+	ActivateAt       *time.Time       `json:"activate-at,omitempty"`
+	Priority         string           `json:"priority"`
+	NewPricePerEC    string           `json:"new-price-per-EC"`
+	Status           string           `json:"status"`
+	CreatedAt        time.Time        `json:"created-at"`
+	UpdatedAt        time.Time        `json:"updated-at"`
+	Result           *addressResponse `json:"result,omitempty"`
+	TxID             string           `json:"txid,omitempty"`
+	EntryHash        string           `json:"entry-hash,omitempty"`
+	Error            string           `json:"error,omitempty"`
+}
+
+type scheduleRequest struct {
+	ID               string     `json:"id"`
+	ActivationHeight string     `json:"activation-height"`
+	ActivateAt       *time.Time `json:"activate-at"`
+	Priority         string     `json:"priority"`
+	NewPricePerEC    string     `json:"new-price-per-EC"`
+}
+
+type scheduleIDRequest struct {
+	ID string `json:"id"`
+}
+
+type listScheduleRequest struct {
+	Status string `json:"status"`
+}
+
+// Guards the schedule file, like proposalLock.
+var scheduleLock sync.Mutex
+
+func newScheduleID(s *ScheduledChange) string {
+	content, _ := json.Marshal(struct {
+		ActivationHeight uint32
+		ActivateAt       *time.Time
+		Priority         string
+		NewPricePerEC    string
+		CreatedAt        int64
+	}{s.ActivationHeight, s.ActivateAt, s.Priority, s.NewPricePerEC, s.CreatedAt.UnixNano()})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Applies the trigger and price of a request to s, checking them first.
+func (s *ScheduledChange) apply(req scheduleRequest) error {
+	if (req.ActivationHeight == "") == (req.ActivateAt == nil) {
+		return errors.New("Give either activation-height or activate-at")
+	}
+	if req.Priority == "" {
+		req.Priority = "1"
+	}
+	if _, err := strconv.ParseUint(req.Priority, 10, 32); err != nil {
+		return errors.New(fmt.Sprintf("Invalid priority: %s", req.Priority))
+	}
+	price, err := strconv.ParseUint(req.NewPricePerEC, 10, 64)
+	if err != nil || price == 0 {
+		return errors.New(fmt.Sprintf("Invalid new-price-per-EC: %s", req.NewPricePerEC))
+	}
+
+	s.ActivationHeight = 0
+	s.ActivateAt = nil
+	if req.ActivateAt != nil {
+		if !req.ActivateAt.After(time.Now()) {
+			return errors.New("activate-at is in the past")
+		}
+		at := req.ActivateAt.UTC()
+		s.ActivateAt = &at
+	} else {
+		height, err := strconv.ParseUint(req.ActivationHeight, 10, 32)
+		if err != nil || height < 2 {
+			return errors.New(fmt.Sprintf("Invalid activation-height: %s", req.ActivationHeight))
+		}
+		s.ActivationHeight = uint32(height)
+	}
+	s.Priority = req.Priority
+	s.NewPricePerEC = req.NewPricePerEC
+	return nil
+}
+
+// The activation height the change goes out with, given the leader height now.
+// For activate-at the height is only an estimate until the change is due.
+func (s *ScheduledChange) targetHeight(leaderHeight int64, now time.Time) int64 {
+	if s.ActivateAt == nil {
+		return int64(s.ActivationHeight)
+	}
+	blocks := int64((s.ActivateAt.Sub(now) + blockTime - 1) / blockTime)
+	return leaderHeight + blocks
+}
+
+func loadSchedule(fileName string) (map[string]*ScheduledChange, error) {
+	schedule := make(map[string]*ScheduledChange)
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return schedule, nil
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not read schedule file %s: %s", fileName, err))
+	}
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not parse schedule file %s: %s", fileName, err))
+	}
+	return schedule, nil
+}
+
+// Written through a temp file and a rename, as saveProposals does
+func saveSchedule(fileName string, schedule map[string]*ScheduledChange) error {
+	data, err := json.MarshalIndent(schedule, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.New(fmt.Sprintf("Could not write schedule file %s: %s", tmp, err))
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// Scheduled changes sign without approvals, so like change-price they are
+// refused when approvals are configured.
+func checkSchedulingAllowed(config Config) error {
+	if config.ApprovalsRequired > 0 {
+		err := errors.New("FER changes require approval, use propose-fer-change")
+		publishPolicyRejection("scheduler", err.Error())
+		return err
+	}
+	return nil
+}
+
+func ScheduleChange(config Config, req scheduleRequest) (*ScheduledChange, error) {
+	if err := checkSchedulingAllowed(config); err != nil {
+		return nil, err
+	}
+	s := new(ScheduledChange)
+	if err := s.apply(req); err != nil {
+		return nil, err
+	}
+
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, err := loadSchedule(config.GetScheduleFile())
+	if err != nil {
+		return nil, err
+	}
+	s.Status = ScheduleScheduled
+	s.CreatedAt = time.Now().UTC()
+	s.UpdatedAt = s.CreatedAt
+	s.ID = newScheduleID(s)
+	schedule[s.ID] = s
+
+	if err := saveSchedule(config.GetScheduleFile(), schedule); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RescheduleChange replaces the trigger and price of a change that hasn't gone
+// out yet.  The id stays the same.
+func RescheduleChange(config Config, req scheduleRequest) (*ScheduledChange, error) {
+	if err := checkSchedulingAllowed(config); err != nil {
+		return nil, err
+	}
+	return updateScheduledChange(config, req.ID, func(s *ScheduledChange) error {
+		return s.apply(req)
+	})
+}
+
+func CancelScheduledChange(config Config, id string) (*ScheduledChange, error) {
+	return updateScheduledChange(config, id, func(s *ScheduledChange) error {
+		s.Status = ScheduleCancelled
+		return nil
+	})
+}
+
+func updateScheduledChange(config Config, id string, change func(s *ScheduledChange) error) (*ScheduledChange, error) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, err := loadSchedule(config.GetScheduleFile())
+	if err != nil {
+		return nil, err
+	}
+	s, ok := schedule[id]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("No scheduled change with id %s", id))
+	}
+	if s.Status != ScheduleScheduled {
+		return nil, errors.New(fmt.Sprintf("Scheduled change is %s", s.Status))
+	}
+	if err := change(s); err != nil {
+		return nil, err
+	}
+	s.UpdatedAt = time.Now().UTC()
+
+	if err := saveSchedule(config.GetScheduleFile(), schedule); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func ListScheduledChanges(config Config, status string) ([]*ScheduledChange, error) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, err := loadSchedule(config.GetScheduleFile())
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*ScheduledChange, 0, len(schedule))
+	for _, s := range schedule {
+		if status == "" || s.Status == status {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Runs the scheduler every interval, forever.
+func runScheduler(interval time.Duration) {
+	for {
+		if err := runSchedulerPass(time.Now().UTC()); err != nil {
+			logger.Warn("Scheduler pass failed", "error", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Serializes scheduler passes, so main can wait for one that is submitting.
+// scheduleLock is only held to read and write the schedule file, never across
+// a call to factomd, so the API isn't held up by the network.
+var schedulerPassLock sync.Mutex
+
+// Sends out every scheduled change that is due at the current leader height.
+func runSchedulerPass(now time.Time) error {
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return err
+	}
+
+	schedulerPassLock.Lock()
+	defer schedulerPassLock.Unlock()
+
+	pending, err := failInterruptedChanges(config, now)
+	if err != nil || !pending {
+		return err
+	}
+
+	factom.SetFactomdServer(config.GetFactomdServer())
+	start := time.Now()
+	heights, err := factom.GetHeights()
+	observeFactomd("heights", start)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not read heights: %s", err))
+	}
+
+	due, err := claimDueChanges(config, heights.LeaderHeight, now)
+	if err != nil {
+		return err
+	}
+	for _, s := range due {
+		executeScheduledChange(config, s)
+	}
+	return nil
+}
+
+// A change left submitting was cut off by a crash, as passes don't overlap.
+// It may or may not have reached factomd, so it is not sent again; check the
+// entry store.  Returns whether any change is still scheduled.
+func failInterruptedChanges(config Config, now time.Time) (bool, error) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, err := loadSchedule(config.GetScheduleFile())
+	if err != nil {
+		return false, err
+	}
+	interrupted, pending := false, false
+	for _, s := range schedule {
+		if s.Status == ScheduleSubmitting {
+			s.Status = ScheduleFailed
+			s.Error = "Interrupted while submitting, check the entry store before rescheduling"
+			s.UpdatedAt = now
+			interrupted = true
+		}
+		pending = pending || s.Status == ScheduleScheduled
+	}
+	if interrupted {
+		if err := saveSchedule(config.GetScheduleFile(), schedule); err != nil {
+			return false, err
+		}
+	}
+	return pending, nil
+}
+
+// Marks the changes due at leader as submitting, or missed when too late, and
+// returns the ones to submit.  They can't be rescheduled or cancelled while
+// they go out.
+func claimDueChanges(config Config, leader int64, now time.Time) ([]*ScheduledChange, error) {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, err := loadSchedule(config.GetScheduleFile())
+	if err != nil {
+		return nil, err
+	}
+	due := make([]*ScheduledChange, 0)
+	for _, s := range schedule {
+		if s.Status == ScheduleScheduled && s.targetHeight(leader, now)-leader <= int64(config.GetScheduleLeadBlocks()) {
+			due = append(due, s)
+		}
+	}
+	if len(due) == 0 {
+		return due, nil
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+
+	claimed := make([]*ScheduledChange, 0, len(due))
+	for _, s := range due {
+		s.UpdatedAt = time.Now().UTC()
+		activation := s.targetHeight(leader, now)
+		// The entry must land in a block before activation - 1 for the
+		// expiration height to be ahead of the leader
+		if activation-1 <= leader {
+			s.Status = ScheduleMissed
+			s.Error = fmt.Sprintf("Activation height %d not ahead of leader height %d", activation, leader)
+			logger.Warn("Scheduled change missed", "component", "scheduler", "schedule_id", s.ID, "activation_height", activation, "leader_height", leader)
+			continue
+		}
+		if err := checkSchedulingAllowed(config); err != nil {
+			s.Status = ScheduleFailed
+			s.Error = err.Error()
+			continue
+		}
+		s.ActivationHeight = uint32(activation)
+		s.Status = ScheduleSubmitting
+		claimed = append(claimed, s)
+	}
+	if err := saveSchedule(config.GetScheduleFile(), schedule); err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// Composes and submits a claimed change, then records how it went.
+func executeScheduledChange(config Config, s *ScheduledChange) {
+	log := logger.With("component", "scheduler", "schedule_id", s.ID)
+	defer func() {
+		if err := saveScheduledChange(config, s); err != nil {
+			log.Error("Could not save schedule", "error", err)
+		}
+	}()
+
+	activation := int64(s.ActivationHeight)
+	entry, reveal, targetPriceInDollars, ecAddress, err := CreateFEREntryAndReveal(
+		strconv.FormatInt(activation-1, 10), strconv.FormatInt(activation, 10), s.Priority, s.NewPricePerEC)
+	if err != nil {
+		s.Status = ScheduleFailed
+		s.Error = err.Error()
+		log.Error("Could not compose scheduled change", "error", err)
+		return
+	}
+	metricComposed.inc("scheduler")
+	s.Result = &addressResponse{EntryCommitJson: entry, RevealJson: reveal, TargetPriceInDollars: targetPriceInDollars, ECAddress: ecAddress}
+
+	s.TxID, s.EntryHash, err = SubmitFEREntry(config.GetFactomdServer(), entry, reveal)
+	if err != nil {
+		s.Status = ScheduleFailed
+		s.Error = err.Error()
+		log.Error("Could not submit scheduled change", "error", err)
+		return
+	}
+	metricSubmitted.inc("scheduler")
+	s.Status = ScheduleSubmitted
+	s.Error = ""
+	log.Info("Scheduled change submitted", "activation_height", activation, "entry_hash", s.EntryHash, "txid", s.TxID)
+}
+
+// Writes back a change the scheduler claimed.  Nothing else touches a
+// submitting change, so it replaces whatever the file holds for it.
+func saveScheduledChange(config Config, s *ScheduledChange) error {
+	scheduleLock.Lock()
+	defer scheduleLock.Unlock()
+
+	schedule, err := loadSchedule(config.GetScheduleFile())
+	if err != nil {
+		return err
+	}
+	s.UpdatedAt = time.Now().UTC()
+	schedule[s.ID] = s
+	return saveSchedule(config.GetScheduleFile(), schedule)
+}
+
+func handleScheduleFERChange(params []byte) (interface{}, *factom.JSONError) {
+	req := new(scheduleRequest)
+	if err := json.Unmarshal(params, req); err != nil {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	s, err := ScheduleChange(config, *req)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return s, nil
+}
+
+func handleRescheduleFERChange(params []byte) (interface{}, *factom.JSONError) {
+	req := new(scheduleRequest)
+	if err := json.Unmarshal(params, req); err != nil || req.ID == "" {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	s, err := RescheduleChange(config, *req)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return s, nil
+}
+
+func handleCancelScheduledChange(params []byte) (interface{}, *factom.JSONError) {
+	req := new(scheduleIDRequest)
+	if err := json.Unmarshal(params, req); err != nil || req.ID == "" {
+		return nil, newInvalidParamsError()
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	s, err := CancelScheduledChange(config, req.ID)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return s, nil
+}
+
+func handleListScheduledChanges(params []byte) (interface{}, *factom.JSONError) {
+	req := new(listScheduleRequest)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, req); err != nil {
+			return nil, newInvalidParamsError()
+		}
+	}
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	list, err := ListScheduledChanges(config, req.Status)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return list, nil
+}
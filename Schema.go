@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FEREntry content versions.  factomd reads only the fields of 1.0 and
+// ignores the rest, so 2.0 entries change the rate the same way.
+const (
+	FERVersion1 = "1.0"
+	FERVersion2 = "2.0"
+)
+
+// An FERSchema says what an FEREntry of its version may hold.  Entries are
+// checked against the schema of their Version when composed and when read
+// back from the chain or from content to sign; a version without a schema
+// is rejected.
+type FERSchema struct {
+	Version string
+	// Every field must be in the content
+	Required []string
+	// May be left out
+	Optional []string
+	// Checks the values once decoded
+	Validate func(fer *FEREntry) error
+}
+
+var ferSchemas = make(map[string]*FERSchema)
+
+func registerFERSchema(s *FERSchema) {
+	ferSchemas[s.Version] = s
+}
+
+var ferV1Fields = []string{"expiration_height", "target_activation_height", "priority", "target_price", "version"}
+
+func init() {
+	registerFERSchema(&FERSchema{
+		Version:  FERVersion1,
+		Required: ferV1Fields,
+		Validate: validateFERv1,
+	})
+	registerFERSchema(&FERSchema{
+		Version:  FERVersion2,
+		Required: ferV1Fields,
+		Optional: []string{"reason_code", "proposer", "proposal_ref"},
+		Validate: validateFERv2,
+	})
+}
+
+func ferSchema(version string) (*FERSchema, error) {
+	s, ok := ferSchemas[version]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unknown FEREntry version %q, known versions are %s", version, strings.Join(ferVersions(), ", ")))
+	}
+	return s, nil
+}
+
+func ferVersions() []string {
+	versions := make([]string, 0, len(ferSchemas))
+	for v := range ferSchemas {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+func validateFERv1(fer *FEREntry) error {
+	if fer.TargetPrice == 0 {
+		return errors.New("Trying to set targetPrice to 0!")
+	}
+	if fer.ReasonCode != "" || fer.Proposer != "" || fer.ProposalRef != "" {
+		return errors.New(fmt.Sprintf("Reason code, proposer and proposal reference need FEREntry version %s", FERVersion2))
+	}
+	return nil
+}
+
+var reasonCode = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+const maxFERMetadataLength = 64
+
+func validateFERv2(fer *FEREntry) error {
+	if fer.TargetPrice == 0 {
+		return errors.New("Trying to set targetPrice to 0!")
+	}
+	if fer.ReasonCode != "" && !reasonCode.MatchString(fer.ReasonCode) {
+		return errors.New(fmt.Sprintf("Invalid reason code %q: lower case letters, digits, - and _, up to 32", fer.ReasonCode))
+	}
+	for name, value := range map[string]string{"proposer": fer.Proposer, "proposal reference": fer.ProposalRef} {
+		if len(value) > maxFERMetadataLength {
+			return errors.New(fmt.Sprintf("The %s is longer than %d bytes", name, maxFERMetadataLength))
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return errors.New(fmt.Sprintf("The %s has control characters", name))
+		}
+	}
+	return nil
+}
+
+// Checks fer against the schema of its version, for the compose path.
+func (fer *FEREntry) validate() error {
+	s, err := ferSchema(fer.Version)
+	if err != nil {
+		return err
+	}
+	return s.Validate(fer)
+}
+
+// Reads FEREntry content from the chain as factomd does: any version, with
+// the fields it doesn't know ignored.  decodeFEREntry says whether the
+// content also fits its schema.
+func readChainFEREntry(content []byte) (*FEREntry, error) {
+	fer := new(FEREntry)
+	if err := json.Unmarshal(content, fer); err != nil {
+		return nil, errors.New(fmt.Sprintf("Content is not an FEREntry: %s", err))
+	}
+	return fer, nil
+}
+
+// Reads FEREntry content: the fields must be those of its version's schema.
+func decodeFEREntry(content []byte) (*FEREntry, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, errors.New(fmt.Sprintf("Content is not an FEREntry: %s", err))
+	}
+	var version string
+	if err := json.Unmarshal(fields["version"], &version); err != nil {
+		return nil, errors.New("Content is not an FEREntry: no version")
+	}
+	s, err := ferSchema(version)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range s.Required {
+		if _, ok := fields[name]; !ok {
+			return nil, errors.New(fmt.Sprintf("FEREntry version %s needs %s", version, name))
+		}
+		allowed[name] = true
+	}
+	for _, name := range s.Optional {
+		allowed[name] = true
+	}
+	for name := range fields {
+		if !allowed[name] {
+			return nil, errors.New(fmt.Sprintf("FEREntry version %s has no field %s", version, name))
+		}
+	}
+
+	fer := new(FEREntry)
+	if err := json.Unmarshal(content, fer); err != nil {
+		return nil, errors.New(fmt.Sprintf("Content is not an FEREntry: %s", err))
+	}
+	if err := s.Validate(fer); err != nil {
+		return nil, err
+	}
+	return fer, nil
+}
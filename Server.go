@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// runServer serves webServer on addr until SIGTERM or SIGINT.  On a signal it
+// stops accepting connections and waits for in-flight requests, so a compose
+// or a commit and reveal is never cut off half way.
+func runServer(addr string) error {
+	listener, err := listen(addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:      http.MaxBytesHandler(webServer, *maxBodyFlag),
+		ReadTimeout:  *readTimeoutFlag,
+		WriteTimeout: *writeTimeoutFlag,
+		IdleTimeout:  *idleTimeoutFlag,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+		logger.Info("Shutting down, finishing in-flight requests", "signal", sig.String())
+		close(stopStreams)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeoutFlag)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	logger.Info("fer-api serving", "addr", addr)
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
+
+// Listens on host:port, or on a unix socket for "unix:/path/to.sock".
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		// A socket left behind by an unclean exit would block the listen
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
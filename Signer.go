@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// A Signer holds one of the two keys fer-api signs with: the FER signing key
+// signs the FEREntry content and the payment key signs the entry commit.
+type Signer interface {
+	PublicKey() (*[ed.PublicKeySize]byte, error)
+	Sign(msg []byte) (*[ed.SignatureSize]byte, error)
+}
+
+// Where a key lives when it isn't given as hex in the config file.
+//
+//	Type = "memory"   - the hex key in SigningPrivateKey / PaymentPrivateKey
+//	Type = "keystore" - a passphrase encrypted file made by "fer-api keystore"
+//	Type = "command"  - an external program speaking the JSON protocol below
+type KeyBackend struct {
+	Type string
+	// keystore: the file, and the environment variable holding its passphrase
+	Keystore      string
+	PassphraseEnv string
+	// command: the program and its arguments, and how long a call may take
+	Command []string
+	Timeout string
+}
+
+func newSigner(backend KeyBackend, hexKey string) (Signer, error) {
+	switch backend.Type {
+	case "", "memory":
+		if hexKey == "" {
+			return nil, errors.New("No private key configured")
+		}
+		return newMemorySigner(hexKey)
+	case "keystore":
+		return openKeystore(backend.Keystore, backend.PassphraseEnv)
+	case "command":
+		return newCommandSigner(backend.Command, backend.Timeout)
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown key backend type: %s", backend.Type))
+}
+
+// Key held in memory
+type memorySigner struct {
+	privateKey *[ed.PrivateKeySize]byte
+}
+
+func newMemorySigner(hexKey string) (*memorySigner, error) {
+	privateKey, err := signingKeyFromHex(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	return &memorySigner{privateKey: privateKey}, nil
+}
+
+func (s *memorySigner) PublicKey() (*[ed.PublicKeySize]byte, error) {
+	return ed.GetPublicKey(s.privateKey), nil
+}
+
+func (s *memorySigner) Sign(msg []byte) (*[ed.SignatureSize]byte, error) {
+	return ed.Sign(s.privateKey, msg), nil
+}
+
+// An external program does the signing, so an HSM or KMS bridge can hold the
+// key without fer-api linking its SDK.  The program is started once per call,
+// gets one JSON request on stdin and writes one JSON response to stdout:
+//
+//	{"method":"public-key"}                 -> {"public-key":"<hex>"}
+//	{"method":"sign","message":"<hex>"}     -> {"signature":"<hex>"}
+//
+// Failures are reported as {"error":"<text>"} or a non-zero exit.
+type commandSigner struct {
+	command []string
+	timeout time.Duration
+
+	lock      sync.Mutex
+	publicKey *[ed.PublicKeySize]byte
+}
+
+type commandRequest struct {
+	Method  string `json:"method"`
+	Message string `json:"message,omitempty"`
+}
+
+type commandResponse struct {
+	PublicKey string `json:"public-key"`
+	Signature string `json:"signature"`
+	Error     string `json:"error"`
+}
+
+func newCommandSigner(command []string, timeout string) (*commandSigner, error) {
+	if len(command) == 0 {
+		return nil, errors.New("Key backend command is empty")
+	}
+	s := &commandSigner{command: command, timeout: 10 * time.Second}
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid key backend timeout: %s", timeout))
+		}
+		s.timeout = d
+	}
+	return s, nil
+}
+
+func (s *commandSigner) call(req commandRequest) (*commandResponse, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Signer command %s failed: %s %s", s.command[0], err, stderr.String()))
+	}
+
+	resp := new(commandResponse)
+	if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, errors.New(fmt.Sprintf("Signer command %s returned bad JSON: %s", s.command[0], err))
+	}
+	if resp.Error != "" {
+		return nil, errors.New(fmt.Sprintf("Signer command %s: %s", s.command[0], resp.Error))
+	}
+	return resp, nil
+}
+
+func (s *commandSigner) PublicKey() (*[ed.PublicKeySize]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.publicKey != nil {
+		return s.publicKey, nil
+	}
+	resp, err := s.call(commandRequest{Method: "public-key"})
+	if err != nil {
+		return nil, err
+	}
+	publicBytes, err := hex.DecodeString(resp.PublicKey)
+	if err != nil || len(publicBytes) != ed.PublicKeySize {
+		return nil, errors.New("Signer command returned a bad public key")
+	}
+	s.publicKey = new([ed.PublicKeySize]byte)
+	copy(s.publicKey[:], publicBytes)
+	return s.publicKey, nil
+}
+
+// The signature is checked against the public key before it is used, so a
+// misbehaving backend can't get a bad signature into an entry.
+func (s *commandSigner) Sign(msg []byte) (*[ed.SignatureSize]byte, error) {
+	publicKey, err := s.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.call(commandRequest{Method: "sign", Message: hex.EncodeToString(msg)})
+	if err != nil {
+		return nil, err
+	}
+	sigBytes, err := hex.DecodeString(resp.Signature)
+	if err != nil || len(sigBytes) != ed.SignatureSize {
+		return nil, errors.New("Signer command returned a bad signature")
+	}
+	var sig [ed.SignatureSize]byte
+	copy(sig[:], sigBytes)
+	if !ed.VerifyCanonical(publicKey, msg, &sig) {
+		return nil, errors.New("Signer command signature does not verify")
+	}
+	return &sig, nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"github.com/FactomProject/goleveldb/leveldb/opt"
+	"github.com/FactomProject/goleveldb/leveldb/util"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The entry store keeps every FER entry fer-api composes, from the signed
+// content through commit, reveal and submission.  Keys:
+//   e:<entry hash>                         the StoredEntry as JSON
+//   a:<activation height>:<entry hash>     index by activation height
+//   p:<proposal id>:<entry hash>           index by proposal
+// Heights are zero padded so the index iterates in height order.
+
+const (
+	EntryComposed  = "composed"
+	EntrySubmitted = "submitted"
+	EntryFailed    = "failed"
+)
+
+type StoredEntry struct {
+	EntryHash       string               `json:"entry-hash"`
+	FEREntry        FEREntry             `json:"fer-entry"`
+	Content         string               `json:"content"`
+	Signature       string               `json:"signature"`
+	EntryCommitJson string               `json:"entry-commit"`
+	RevealJson      string               `json:"reveal-entry"`
+	ECAddress       string               `json:"ec-address"`
+	TxID            string               `json:"txid,omitempty"`
+	ProposalID      string               `json:"proposal-id,omitempty"`
+	State           string               `json:"state"`
+	Error           string               `json:"error,omitempty"`
+	CreatedAt       time.Time            `json:"created-at"`
+	UpdatedAt       time.Time            `json:"updated-at"`
+	StateTimes      map[string]time.Time `json:"state-times"`
+}
+
+func (e *StoredEntry) setState(state string, now time.Time) {
+	e.State = state
+	e.UpdatedAt = now
+	if e.StateTimes == nil {
+		e.StateTimes = make(map[string]time.Time)
+	}
+	e.StateTimes[state] = now
+}
+
+type EntryStore struct {
+	db *leveldb.DB
+	// Serializes read-modify-write updates of one entry
+	lock sync.Mutex
+}
+
+// Opened by the server and by compose; nil when fer-api runs without one
+// (e.g. other CLI commands).
+var entryStore *EntryStore
+
+func OpenEntryStore(path string) (*EntryStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not open entry store %s: %s", path, err))
+	}
+	return &EntryStore{db: db}, nil
+}
+
+func (s *EntryStore) Close() error {
+	return s.db.Close()
+}
+
+func entryKey(hash string) []byte {
+	return []byte("e:" + hash)
+}
+
+func activationKey(height uint32, hash string) []byte {
+	return []byte(fmt.Sprintf("a:%010d:%s", height, hash))
+}
+
+func proposalKey(proposalID string, hash string) []byte {
+	return []byte("p:" + proposalID + ":" + hash)
+}
+
+// Put writes the entry and its index keys in one batch.
+func (s *EntryStore) Put(e *StoredEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(entryKey(e.EntryHash), data)
+	batch.Put(activationKey(e.FEREntry.TargetActivationHeight, e.EntryHash), nil)
+	if e.ProposalID != "" {
+		batch.Put(proposalKey(e.ProposalID, e.EntryHash), nil)
+	}
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (s *EntryStore) Get(hash string) (*StoredEntry, error) {
+	data, err := s.db.Get(entryKey(strings.ToLower(hash)), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, errors.New(fmt.Sprintf("No local entry with hash %s", hash))
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := new(StoredEntry)
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Update applies change to a stored entry and writes it back.
+func (s *EntryStore) Update(hash string, change func(e *StoredEntry)) (*StoredEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, err := s.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	change(e)
+	return e, s.Put(e)
+}
+
+type EntryFilter struct {
+	ProposalID           string `json:"proposal-id"`
+	FromActivationHeight uint32 `json:"from-activation-height"`
+	ToActivationHeight   uint32 `json:"to-activation-height"`
+	State                string `json:"state"`
+	Limit                int    `json:"limit"`
+}
+
+// List walks the proposal index when a proposal id is given and the
+// activation height index otherwise, in activation height order.  A Limit
+// keeps the last entries of that order, the newest.
+func (s *EntryStore) List(filter EntryFilter) ([]*StoredEntry, error) {
+	var slice *util.Range
+	if filter.ProposalID != "" {
+		slice = util.BytesPrefix([]byte("p:" + filter.ProposalID + ":"))
+	} else {
+		to := []byte("a;") // just past every "a:" key
+		// At math.MaxUint32 the next height would wrap, so that range stays open
+		if filter.ToActivationHeight > 0 && filter.ToActivationHeight < math.MaxUint32 {
+			to = activationKey(filter.ToActivationHeight+1, "")
+		}
+		slice = &util.Range{Start: activationKey(filter.FromActivationHeight, ""), Limit: to}
+	}
+
+	list := make([]*StoredEntry, 0)
+	iter := s.db.NewIterator(slice, nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := string(iter.Key())
+		e, err := s.Get(key[strings.LastIndex(key, ":")+1:])
+		if err != nil {
+			return nil, err
+		}
+		height := e.FEREntry.TargetActivationHeight
+		if height < filter.FromActivationHeight || (filter.ToActivationHeight > 0 && height > filter.ToActivationHeight) {
+			continue
+		}
+		if filter.State != "" && e.State != filter.State {
+			continue
+		}
+		list = append(list, e)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].FEREntry.TargetActivationHeight != list[j].FEREntry.TargetActivationHeight {
+			return list[i].FEREntry.TargetActivationHeight < list[j].FEREntry.TargetActivationHeight
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	// The limit keeps the newest, the last in that order
+	if filter.Limit > 0 && len(list) > filter.Limit {
+		list = list[len(list)-filter.Limit:]
+	}
+	return list, nil
+}
+
+// Backup writes every stored entry as one JSON object per line, from a
+// snapshot so it is consistent while the server keeps writing.
+func (s *EntryStore) Backup(w io.Writer) (int, error) {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.Release()
+
+	count := 0
+	iter := snap.NewIterator(util.BytesPrefix([]byte("e:")), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if _, err := w.Write(append(append([]byte{}, iter.Value()...), '\n')); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, iter.Error()
+}
+
+// Restore reads a backup and puts every entry back, indexes included.
+func (s *EntryStore) Restore(r io.Reader) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		e := new(StoredEntry)
+		if err := json.Unmarshal([]byte(line), e); err != nil || e.EntryHash == "" {
+			return count, errors.New(fmt.Sprintf("Bad backup record %d", count+1))
+		}
+		if err := s.Put(e); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// Records a freshly composed entry.  Composing the same content again gives
+// the same entry hash; the new commit replaces the old one but the history is
+// kept.
+func recordComposedEntry(fer *FEREntry, entryJson []byte, signature []byte, commitJson string, revealJson string, ecAddress string) error {
+	if entryStore == nil {
+		return nil
+	}
+	hash := entryHashFromReveal(revealJson)
+	now := time.Now().UTC()
+
+	entryStore.lock.Lock()
+	defer entryStore.lock.Unlock()
+
+	e, err := entryStore.Get(hash)
+	if err != nil {
+		e = &StoredEntry{EntryHash: hash, CreatedAt: now}
+	} else if e.State == EntrySubmitted {
+		// Keep the commit and reveal that actually went to factomd
+		return nil
+	}
+	e.FEREntry = *fer
+	e.Content = string(entryJson)
+	e.Signature = hex.EncodeToString(signature)
+	e.EntryCommitJson = commitJson
+	e.RevealJson = revealJson
+	e.ECAddress = ecAddress
+	e.Error = ""
+	e.setState(EntryComposed, now)
+	if err := entryStore.Put(e); err != nil {
+		return err
+	}
+	publishEvent(EventComposed, hash, fer)
+	return nil
+}
+
+func recordSubmittedEntry(revealJson string, txID string, submitErr error) {
+	if entryStore == nil {
+		return
+	}
+	hash := entryHashFromReveal(revealJson)
+	_, err := entryStore.Update(hash, func(e *StoredEntry) {
+		now := time.Now().UTC()
+		if submitErr != nil {
+			e.Error = submitErr.Error()
+			e.setState(EntryFailed, now)
+			return
+		}
+		e.TxID = txID
+		e.Error = ""
+		e.setState(EntrySubmitted, now)
+	})
+	if err != nil {
+		logger.Error("Could not record submitted entry", "entry_hash", hash, "error", err)
+	}
+}
+
+func recordEntryProposal(revealJson string, proposalID string) {
+	if entryStore == nil {
+		return
+	}
+	hash := entryHashFromReveal(revealJson)
+	_, err := entryStore.Update(hash, func(e *StoredEntry) { e.ProposalID = proposalID })
+	if err != nil {
+		logger.Error("Could not record entry proposal", "entry_hash", hash, "proposal_id", proposalID, "error", err)
+	}
+}
+
+type getEntryRequest struct {
+	EntryHash string `json:"entry-hash"`
+}
+
+func handleGetFEREntry(params []byte) (interface{}, *factom.JSONError) {
+	req := new(getEntryRequest)
+	if err := json.Unmarshal(params, req); err != nil || req.EntryHash == "" {
+		return nil, newInvalidParamsError()
+	}
+	if entryStore == nil {
+		return nil, newCustomInternalError("No entry store")
+	}
+	e, err := entryStore.Get(req.EntryHash)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return e, nil
+}
+
+func handleListLocalFEREntries(params []byte) (interface{}, *factom.JSONError) {
+	filter := new(EntryFilter)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, filter); err != nil {
+			return nil, newInvalidParamsError()
+		}
+	}
+	if entryStore == nil {
+		return nil, newCustomInternalError("No entry store")
+	}
+	list, err := entryStore.List(*filter)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	return list, nil
+}
+
+// "fer-api backup" and "fer-api restore".  leveldb allows one process at a
+// time, so run these with the server stopped.
+func runBackupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	conf := flags.String("config", configFileName, "config file naming the entry store")
+	out := flags.String("out", "", "backup file to write (default stdout)")
+	flags.Parse(args)
+
+	store, err := openStoreFromConfig(*conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not create backup file: %s", err))
+		}
+		defer f.Close()
+		w = f
+	}
+	count, err := store.Backup(w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %d entries\n", count)
+	return nil
+}
+
+func runRestoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	conf := flags.String("config", configFileName, "config file naming the entry store")
+	in := flags.String("in", "", "backup file to read (default stdin)")
+	flags.Parse(args)
+
+	store, err := openStoreFromConfig(*conf)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	r := io.Reader(os.Stdin)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Could not open backup file: %s", err))
+		}
+		defer f.Close()
+		r = f
+	}
+	count, err := store.Restore(r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d entries\n", count)
+	return nil
+}
+
+func openStoreFromConfig(conf string) (*EntryStore, error) {
+	config, err := readConfigFile(conf)
+	if err != nil {
+		return nil, err
+	}
+	return OpenEntryStore(config.GetEntryStorePath())
+}
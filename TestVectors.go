@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Known answers for every step of composing an FER entry, for golden tests
+// here and for checking other implementations:
+//
+//	FEREntry -> content (its JSON) -> signature (ExtID 0) -> entry hash
+//	         -> commit message (with CommitTimestamp) and reveal (the entry)
+//
+// ed25519 signatures don't depend on anything but the key and message, so
+// with the timestamp fixed every value is reproducible.  "fer-api
+// test-vectors" checks the table against this build and prints it as JSON.
+type TestVector struct {
+	Name string `json:"name"`
+	// Hex 32 byte private keys
+	SigningKey string   `json:"signing-key"`
+	PaymentKey string   `json:"payment-key"`
+	FEREntry   FEREntry `json:"fer-entry"`
+	// Unix milliseconds
+	CommitTimestamp int64 `json:"commit-timestamp"`
+
+	// Hex of each result
+	Content   string `json:"content"`
+	Signature string `json:"signature"`
+	EntryHash string `json:"entry-hash"`
+	Commit    string `json:"commit"`
+	Reveal    string `json:"reveal"`
+}
+
+var testVectors = []TestVector{
+	{
+		Name:            "local network keys",
+		SigningKey:      "0000000000000000000000000000000000000000000000000000000000000000",
+		PaymentKey:      "0000000000000000000000000000000000000000000000000000000000000000",
+		FEREntry:        FEREntry{ExpirationHeight: 95, TargetActivationHeight: 94, Priority: 1, TargetPrice: 6000, Version: "1.0"},
+		CommitTimestamp: 1541174093000,
+		Content:         "7b2265787069726174696f6e5f686569676874223a39352c227461726765745f61637469766174696f6e5f686569676874223a39342c227072696f72697479223a312c227461726765745f7072696365223a363030302c2276657273696f6e223a22312e30227d",
+		Signature:       "950e7e7e265611b4b704591b7fd9d8ba6cb7b0df194af0b91d46f1e1297046a3d0f0924dd154b4e97d99ac397f3f58fcf3094e4042bd37e1bc938030b2743805",
+		EntryHash:       "2c90f82a8ddd21bee8493471b9890e03d719fa536e9611f82513711a4a56ec29",
+		Commit:          "000166d52264c82c90f82a8ddd21bee8493471b9890e03d719fa536e9611f82513711a4a56ec29013b6a27bcceb6a42d62a3a8d02a6f0d73653215771de243a63ac048a18b59da290620f85baa50b4bc409a2e426c3e4fd4fb817657994928cdf92d28c0880112da642a566bcfd82fea81cadab77e800bae4779ef0c7081c8b78c3fd54912886f06",
+		Reveal:          "00111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf0300420040950e7e7e265611b4b704591b7fd9d8ba6cb7b0df194af0b91d46f1e1297046a3d0f0924dd154b4e97d99ac397f3f58fcf3094e4042bd37e1bc938030b27438057b2265787069726174696f6e5f686569676874223a39352c227461726765745f61637469766174696f6e5f686569676874223a39342c227072696f72697479223a312c227461726765745f7072696365223a363030302c2276657273696f6e223a22312e30227d",
+	},
+	{
+		Name:            "separate keys",
+		SigningKey:      "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		PaymentKey:      "2122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f40",
+		FEREntry:        FEREntry{ExpirationHeight: 201002, TargetActivationHeight: 201003, Priority: 7, TargetPrice: 50000, Version: "1.0"},
+		CommitTimestamp: 1567000000123,
+		Content:         "7b2265787069726174696f6e5f686569676874223a3230313030322c227461726765745f61637469766174696f6e5f686569676874223a3230313030332c227072696f72697479223a372c227461726765745f7072696365223a35303030302c2276657273696f6e223a22312e30227d",
+		Signature:       "bda529d6650ab4888832ae00bf14ebed1febeb9a1bfcf948ba809111e27292e84227c3311b73a3e6832d4b3381af186df29c0e8a217731161590225b0ccbd401",
+		EntryHash:       "438eba0ed9b0397ebe376e370b68db1c3629d9b14919d045794f192be356e888",
+		Commit:          "00016cd87a767b438eba0ed9b0397ebe376e370b68db1c3629d9b14919d045794f192be356e88801e7f162a10bec559afea195e4dce84b69568d5d2cb0963eb446c0685e2b17f2f0cb9b3d83579568751457d859cd35d53b0ad8d73ed3c9c2b239914dc7903d8182163c9c552957d2858bf37e01fb8e96f99efd56fad0a7acf28373c21075f9c900",
+		Reveal:          "00111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf0300420040bda529d6650ab4888832ae00bf14ebed1febeb9a1bfcf948ba809111e27292e84227c3311b73a3e6832d4b3381af186df29c0e8a217731161590225b0ccbd4017b2265787069726174696f6e5f686569676874223a3230313030322c227461726765745f61637469766174696f6e5f686569676874223a3230313030332c227072696f72697479223a372c227461726765745f7072696365223a35303030302c2276657273696f6e223a22312e30227d",
+	},
+	{
+		Name:            "largest values",
+		SigningKey:      "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		PaymentKey:      "2122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f40",
+		FEREntry:        FEREntry{ExpirationHeight: 4294967295, TargetActivationHeight: 4294967295, Priority: 4294967295, TargetPrice: 18446744073709551615, Version: "1.0"},
+		CommitTimestamp: 281474976710655,
+		Content:         "7b2265787069726174696f6e5f686569676874223a343239343936373239352c227461726765745f61637469766174696f6e5f686569676874223a343239343936373239352c227072696f72697479223a343239343936373239352c227461726765745f7072696365223a31383434363734343037333730393535313631352c2276657273696f6e223a22312e30227d",
+		Signature:       "3271ca818dc649f9f5c0c35e6ea38e75c52a58bbc0692e1b5dcfa444d1376d7d8fae51f5302613082c6aa0cd616e7d37a9f01d45ae66d0d414106b7cdee7f00d",
+		EntryHash:       "29c6527c44bd99f1ba11211dbff43ca20df5e5e2c0edd34a42ef3721b9cc36c6",
+		Commit:          "0004577d95571329c6527c44bd99f1ba11211dbff43ca20df5e5e2c0edd34a42ef3721b9cc36c601e7f162a10bec559afea195e4dce84b69568d5d2cb0963eb446c0685e2b17f2f09bac8f3076b8109273aa4418e8d7fe7e0984cd0ce86ce8776d1c00e8e4508cdb16db8a4bbae7d428fb8004620dc1b7a6f8748d813dc9662b21b27f39ff29ef08",
+		Reveal:          "00111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf03004200403271ca818dc649f9f5c0c35e6ea38e75c52a58bbc0692e1b5dcfa444d1376d7d8fae51f5302613082c6aa0cd616e7d37a9f01d45ae66d0d414106b7cdee7f00d7b2265787069726174696f6e5f686569676874223a343239343936373239352c227461726765745f61637469766174696f6e5f686569676874223a343239343936373239352c227072696f72697479223a343239343936373239352c227461726765745f7072696365223a31383434363734343037333730393535313631352c2276657273696f6e223a22312e30227d",
+	},
+	{
+		Name:            "lowest price",
+		SigningKey:      "0000000000000000000000000000000000000000000000000000000000000000",
+		PaymentKey:      "2122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f40",
+		FEREntry:        FEREntry{ExpirationHeight: 10, TargetActivationHeight: 12, Priority: 0, TargetPrice: 1, Version: "1.0"},
+		CommitTimestamp: 0,
+		Content:         "7b2265787069726174696f6e5f686569676874223a31302c227461726765745f61637469766174696f6e5f686569676874223a31322c227072696f72697479223a302c227461726765745f7072696365223a312c2276657273696f6e223a22312e30227d",
+		Signature:       "7338e8c99eec3b274d986b0572dbe3e90cfa484cccab443a9da0f1f09630233f85b93baa1dbbae8e9a9bad74174e3afd94c5f4781d746b6faf3192785497fa0f",
+		EntryHash:       "dd9836bf897d61082bf3382f70185083a4de4ac6210f6c845b9d1fca94fd5a59",
+		Commit:          "00000000000000dd9836bf897d61082bf3382f70185083a4de4ac6210f6c845b9d1fca94fd5a5901e7f162a10bec559afea195e4dce84b69568d5d2cb0963eb446c0685e2b17f2f0d964b45b97b15472b8f18141f37f01f547c10cfe9098c41b08eeb439a61a0476e80f946c273101ab3298dac319936536659e7fd2533b9bb0fbd69c5ec2672801",
+		Reveal:          "00111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf03004200407338e8c99eec3b274d986b0572dbe3e90cfa484cccab443a9da0f1f09630233f85b93baa1dbbae8e9a9bad74174e3afd94c5f4781d746b6faf3192785497fa0f7b2265787069726174696f6e5f686569676874223a31302c227461726765745f61637469766174696f6e5f686569676874223a31322c227072696f72697479223a302c227461726765745f7072696365223a312c2276657273696f6e223a22312e30227d",
+	},
+	{
+		Name:            "version 2.0 metadata",
+		SigningKey:      "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20",
+		PaymentKey:      "0000000000000000000000000000000000000000000000000000000000000000",
+		FEREntry:        FEREntry{ExpirationHeight: 1004, TargetActivationHeight: 1003, Priority: 2, TargetPrice: 8000, Version: "2.0", ReasonCode: "market", Proposer: "operations", ProposalRef: "5f3a9c"},
+		CommitTimestamp: 1600000000000,
+		Content:         "7b2265787069726174696f6e5f686569676874223a313030342c227461726765745f61637469766174696f6e5f686569676874223a313030332c227072696f72697479223a322c227461726765745f7072696365223a383030302c2276657273696f6e223a22322e30222c22726561736f6e5f636f6465223a226d61726b6574222c2270726f706f736572223a226f7065726174696f6e73222c2270726f706f73616c5f726566223a22356633613963227d",
+		Signature:       "70c111bd9ce6f02285e52d3b711857275951a0fc3f22f7acfebf7cfacc5e3c09b7c218fe9a12a2b97b5f847aa41823bffbba269fe37fdc0e77cef9e1652e6309",
+		EntryHash:       "c68423126e46e3bb2c768fcdf31fa9fa2d72e08c583c896c52ec16ac44b33e3a",
+		Commit:          "000174876e8000c68423126e46e3bb2c768fcdf31fa9fa2d72e08c583c896c52ec16ac44b33e3a013b6a27bcceb6a42d62a3a8d02a6f0d73653215771de243a63ac048a18b59da29dde9673820d533e412bbf6af0dd7165ba5b372484cc7faa3b22878abe572e76ac7cc2382db35f6d9e5ca9dd2546bd75fd12be3dab3a3e259533797a640764309",
+		Reveal:          "00111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf030042004070c111bd9ce6f02285e52d3b711857275951a0fc3f22f7acfebf7cfacc5e3c09b7c218fe9a12a2b97b5f847aa41823bffbba269fe37fdc0e77cef9e1652e63097b2265787069726174696f6e5f686569676874223a313030342c227461726765745f61637469766174696f6e5f686569676874223a313030332c227072696f72697479223a322c227461726765745f7072696365223a383030302c2276657273696f6e223a22322e30222c22726561736f6e5f636f6465223a226d61726b6574222c2270726f706f736572223a226f7065726174696f6e73222c2270726f706f73616c5f726566223a22356633613963227d",
+	},
+}
+
+// Works out every result of v from its inputs.
+func (v TestVector) compute() (*TestVector, error) {
+	signer, err := newMemorySigner(v.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	payer, err := newMemorySigner(v.PaymentKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.FEREntry.validate(); err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(v.FEREntry)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(content)
+	if err != nil {
+		return nil, err
+	}
+
+	e := new(factom.Entry)
+	e.ChainID = FERChainID
+	e.ExtIDs = append(e.ExtIDs, signature[:])
+	e.Content = content
+	entry, err := e.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	commitRequest, err := composeEntryCommit(e, payer, time.Unix(0, v.CommitTimestamp*1e6))
+	if err != nil {
+		return nil, err
+	}
+	commitJson, err := factom.EncodeJSONString(commitRequest)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := jsonRPCHexParam(commitJson, "message")
+	if err != nil {
+		return nil, err
+	}
+
+	r := v
+	r.Content = hex.EncodeToString(content)
+	r.Signature = hex.EncodeToString(signature[:])
+	r.EntryHash = hex.EncodeToString(entryHash(entry))
+	r.Commit = hex.EncodeToString(commit)
+	r.Reveal = hex.EncodeToString(entry)
+	return &r, nil
+}
+
+// The first result of got that differs from v, or "" when all match
+func (v TestVector) mismatch(got *TestVector) string {
+	for _, f := range []struct{ name, want, got string }{
+		{"content", v.Content, got.Content},
+		{"signature", v.Signature, got.Signature},
+		{"entry-hash", v.EntryHash, got.EntryHash},
+		{"commit", v.Commit, got.Commit},
+		{"reveal", v.Reveal, got.Reveal},
+	} {
+		if f.want != f.got {
+			return fmt.Sprintf("%s is %s, want %s", f.name, f.got, f.want)
+		}
+	}
+	return ""
+}
+
+// "fer-api test-vectors" checks the table, or with -json prints it.
+func runTestVectorsCommand(args []string) error {
+	flags := flag.NewFlagSet("test-vectors", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the vectors as JSON instead of checking them")
+	flags.Parse(args)
+
+	results := make([]*TestVector, 0, len(testVectors))
+	failed := 0
+	for _, v := range testVectors {
+		got, err := v.compute()
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: %s", v.Name, err))
+		}
+		results = append(results, got)
+		if *asJSON {
+			continue
+		}
+		if m := v.mismatch(got); m != "" {
+			failed++
+			fmt.Printf("FAIL  %s: %s\n", v.Name, m)
+		} else {
+			fmt.Printf("ok    %s\n", v.Name)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " test vectors failed")
+	}
+	return nil
+}
+
+// A caller's commit-timestamp (unix milliseconds).  Only taken with
+// -deterministic: factomd refuses a commit it has seen, so a fixed timestamp
+// is for tests, never for a live network.
+func commitTimeParam(ms string) (time.Time, error) {
+	if ms == "" {
+		return time.Time{}, nil
+	}
+	if !*deterministicFlag {
+		return time.Time{}, errors.New("commit-timestamp needs the server started with -deterministic")
+	}
+	n, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil || n < 0 || n >= 1<<48 {
+		return time.Time{}, errors.New(fmt.Sprintf("Invalid commit-timestamp: %s", ms))
+	}
+	return time.Unix(0, n*1e6), nil
+}
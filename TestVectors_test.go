@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"testing"
+	"time"
+)
+
+func TestVectors(t *testing.T) {
+	for _, v := range testVectors {
+		got, err := v.compute()
+		if err != nil {
+			t.Errorf("%s: %s", v.Name, err)
+			continue
+		}
+		if m := v.mismatch(got); m != "" {
+			t.Errorf("%s: %s", v.Name, m)
+		}
+	}
+}
+
+// composeEntryCommit only adds the commit time to factom.ComposeEntryCommit,
+// so apart from the timestamp and the signature over it the two commits are
+// the same.
+func TestComposeEntryCommitMatchesFactom(t *testing.T) {
+	for _, v := range testVectors {
+		payer, err := newMemorySigner(v.PaymentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed, _ := hex.DecodeString(v.PaymentKey)
+		ec, err := factom.MakeECAddress(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := new(factom.Entry)
+		e.ChainID = FERChainID
+		signature, _ := hex.DecodeString(v.Signature)
+		e.ExtIDs = append(e.ExtIDs, signature)
+		e.Content, _ = hex.DecodeString(v.Content)
+
+		ours, err := composeEntryCommit(e, payer, time.Unix(0, v.CommitTimestamp*1e6))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theirs, err := factom.ComposeEntryCommit(e, ec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := commitMessage(t, ours)
+		want := commitMessage(t, theirs)
+		if len(got) != len(want) || len(got) != 136 {
+			t.Fatalf("%s: commit is %d bytes, factom's is %d", v.Name, len(got), len(want))
+		}
+		for _, c := range [][]byte{got, want} {
+			var pub [ed.PublicKeySize]byte
+			var sig [ed.SignatureSize]byte
+			copy(pub[:], c[40:72])
+			copy(sig[:], c[72:])
+			if !ed.Verify(&pub, c[:40], &sig) {
+				t.Errorf("%s: commit signature does not verify", v.Name)
+			}
+		}
+		// Mask the 6 byte timestamp and the signature
+		for _, c := range [][]byte{got, want} {
+			copy(c[1:7], make([]byte, 6))
+			copy(c[72:], make([]byte, ed.SignatureSize))
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: commit is %x, factom's is %x", v.Name, got, want)
+		}
+	}
+}
+
+func commitMessage(t *testing.T, r *factom.JSON2Request) []byte {
+	j, err := factom.EncodeJSONString(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := jsonRPCHexParam(j, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
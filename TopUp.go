@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Keeps the payment EC address from running dry.  Every Interval the EC
+// balance is checked, and below Threshold Amount entry credits are bought with
+// factom.BuyExactEC from FactoidAddress, whose key is held in walletd.
+//
+// Every purchase is appended to AuditLog, one JSON line each, as pending
+// before walletd is asked and again once it answers.  No purchase is made
+// unless the pending line is written.  The daily cap is worked out from that
+// log, so it holds across restarts: purchases in the current UTC day, counting
+// fees, may not spend more than DailyCapFCT.  An attempt that failed or never
+// got an answer may still have been broadcast, so it counts as bought, with
+// the fee margin for its fee.  A purchase takes a block to show up in the
+// balance, so after any attempt no other is made for Cooldown.
+type TopUpConfig struct {
+	FactoidAddress string
+	WalletServer   string
+	Threshold      int64
+	Amount         uint64
+	DailyCapFCT    float64
+	Interval       string
+	Cooldown       string
+	AuditLog       string
+}
+
+func (c TopUpConfig) enabled() bool {
+	return c.FactoidAddress != "" && c.Threshold > 0
+}
+
+func (c TopUpConfig) GetWalletServer() string {
+	if c.WalletServer == "" {
+		return "localhost:8089"
+	}
+	return c.WalletServer
+}
+
+func (c TopUpConfig) GetAmount() uint64 {
+	if c.Amount == 0 {
+		return 1000
+	}
+	return c.Amount
+}
+
+func (c TopUpConfig) GetInterval() time.Duration {
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d <= 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+func (c TopUpConfig) GetCooldown() time.Duration {
+	d, err := time.ParseDuration(c.Cooldown)
+	if err != nil || d <= 0 {
+		return 15 * time.Minute
+	}
+	return d
+}
+
+func (c TopUpConfig) GetAuditLog() string {
+	if c.AuditLog == "" {
+		return "FERTopUps.log"
+	}
+	return c.AuditLog
+}
+
+// The daily cap in factoshis
+func (c TopUpConfig) dailyCap() uint64 {
+	return uint64(c.DailyCapFCT * 1e8)
+}
+
+// The fee of a purchase, one input, one EC output and one signature, is 12 EC
+// at the current rate.  This much is reserved for it under the cap until the
+// fee paid is known.
+const topUpFeeMarginEC = 20
+
+const (
+	TopUpPending = "pending"
+	TopUpBought  = "bought"
+	TopUpFailed  = "failed"
+)
+
+type TopUpRecord struct {
+	// The pending line and the result share the id
+	ID             string    `json:"id,omitempty"`
+	Status         string    `json:"status,omitempty"`
+	Time           time.Time `json:"time"`
+	FactoidAddress string    `json:"factoid-address"`
+	ECAddress      string    `json:"ec-address"`
+	BalanceBefore  int64     `json:"balance-before"`
+	Amount         uint64    `json:"amount"`
+	Rate           uint64    `json:"rate"`
+	Factoshis      uint64    `json:"factoshis"`
+	FeesPaid       uint64    `json:"fees-paid"`
+	TxID           string    `json:"txid,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Held for a whole pass, so main can wait for a purchase to finish.
+var topUpLock sync.Mutex
+
+// A result walletd gave that couldn't be written to the audit log.  It is
+// written before anything else on the next pass.
+var unloggedTopUp *TopUpRecord
+
+// What a record counts for under the daily cap
+func (r *TopUpRecord) spent() uint64 {
+	if r.TxID != "" {
+		return r.Factoshis + r.FeesPaid
+	}
+	return r.Factoshis + topUpFeeMarginEC*r.Rate
+}
+
+// The last record of each purchase, in log order.  Lines from before records
+// had ids stand alone.
+func latestTopUps(records []*TopUpRecord) []*TopUpRecord {
+	latest := make([]*TopUpRecord, 0, len(records))
+	index := make(map[string]int)
+	for _, r := range records {
+		if i, ok := index[r.ID]; ok && r.ID != "" {
+			latest[i] = r
+			continue
+		}
+		index[r.ID] = len(latest)
+		latest = append(latest, r)
+	}
+	return latest
+}
+
+func runTopUp() {
+	for {
+		interval := 5 * time.Minute
+		if config, err := readConfigFile(configFileName); err == nil && config.TopUp.enabled() {
+			interval = config.TopUp.GetInterval()
+			if err := runTopUpPass(config, time.Now().UTC()); err != nil {
+				logger.Warn("EC top-up failed", "component", "top-up", "error", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func runTopUpPass(config Config, now time.Time) error {
+	topUpLock.Lock()
+	defer topUpLock.Unlock()
+
+	topUp := config.TopUp
+	if unloggedTopUp != nil {
+		if err := appendJSONLine(topUp.GetAuditLog(), unloggedTopUp); err != nil {
+			return errors.New(fmt.Sprintf("No EC purchases until the audit log can be written, purchase %s is not recorded: %s", unloggedTopUp.ID, err))
+		}
+		unloggedTopUp = nil
+	}
+	ecAddress, err := config.GetPaymentECAddress()
+	if err != nil {
+		return err
+	}
+
+	factom.SetFactomdServer(config.GetFactomdServer())
+	start := time.Now()
+	balance, err := factom.GetECBalance(ecAddress)
+	observeFactomd("entry-credit-balance", start)
+	if err != nil {
+		return err
+	}
+	metricECBalance.set(float64(balance))
+	if balance >= topUp.Threshold {
+		return nil
+	}
+
+	records, err := readTopUps(topUp.GetAuditLog())
+	if err != nil {
+		return err
+	}
+	spent := uint64(0)
+	day := now.Truncate(24 * time.Hour)
+	for _, r := range latestTopUps(records) {
+		if now.Sub(r.Time) < topUp.GetCooldown() {
+			return nil
+		}
+		if !r.Time.Before(day) {
+			spent += r.spent()
+		}
+	}
+
+	start = time.Now()
+	rate, err := factom.GetRate()
+	observeFactomd("entry-credit-rate", start)
+	if err != nil {
+		return err
+	}
+	// The fee isn't known until walletd builds the transaction, so the cap
+	// check reserves the fee margin for it
+	cost := topUp.GetAmount() * rate
+	if spent+cost+topUpFeeMarginEC*rate > topUp.dailyCap() {
+		return errors.New(fmt.Sprintf("EC balance %d is below %d but buying %d EC would pass the daily cap of %g FCT (%d factoshis spent today)",
+			balance, topUp.Threshold, topUp.GetAmount(), topUp.DailyCapFCT, spent))
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	record := &TopUpRecord{ID: hex.EncodeToString(id), Status: TopUpPending, Time: now, FactoidAddress: topUp.FactoidAddress,
+		ECAddress: ecAddress, BalanceBefore: balance, Amount: topUp.GetAmount(), Rate: rate, Factoshis: cost}
+	if err := appendJSONLine(topUp.GetAuditLog(), record); err != nil {
+		return errors.New(fmt.Sprintf("No EC purchase, the audit log can't be written: %s", err))
+	}
+
+	factom.SetWalletServer(topUp.GetWalletServer())
+	tx, err := factom.BuyExactEC(topUp.FactoidAddress, ecAddress, topUp.GetAmount(), false)
+	if err != nil {
+		record.Status = TopUpFailed
+		record.Error = err.Error()
+	} else {
+		record.Status = TopUpBought
+		record.TxID = tx.TxID
+		record.FeesPaid = tx.FeesPaid
+	}
+	if logErr := appendJSONLine(topUp.GetAuditLog(), record); logErr != nil {
+		logger.Error("Could not record EC purchase", "component", "top-up", "id", record.ID, "txid", record.TxID, "error", logErr)
+		unloggedTopUp = record
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not buy entry credits: %s", err))
+	}
+	logger.Info("Bought entry credits", "component", "top-up", "amount", record.Amount, "factoshis", cost, "txid", tx.TxID)
+	return nil
+}
+
+func readTopUps(fileName string) ([]*TopUpRecord, error) {
+	list := make([]*TopUpRecord, 0)
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Could not open top-up log %s: %s", fileName, err))
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := new(TopUpRecord)
+		if err := json.Unmarshal(scanner.Bytes(), r); err == nil {
+			list = append(list, r)
+		}
+	}
+	return list, scanner.Err()
+}
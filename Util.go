@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"io"
 	"os"
 	"fmt"
 	"strings"
@@ -11,37 +13,49 @@ import (
 
 
 
-// This function creates a simple buffer input and reads a value from teh command line.
-func readStdinUint(prompt string, errorMessage string, intSize int) (uint64, error) {
-
-	reader := bufio.NewReader(os.Stdin)
+// One reader for all prompts, so input buffered for one isn't lost to the next
+var stdinReader = bufio.NewReader(os.Stdin)
 
+// Prints prompt and reads a line from the command line, without the line end.
+func readStdinLine(prompt string) (string, error) {
 	fmt.Print(prompt)
-	text, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Println(errorMessage, "      read = ", text, "    err = ", err)
-		return 0, errors.New(fmt.Sprintf(errorMessage, "  Error: %s", err))
+	text, err := stdinReader.ReadString('\n')
+	if err != nil && (err != io.EOF || text == "") {
+		return "", err
 	}
 	text = strings.Replace(text, "\r", "", -1)
 	text = strings.Replace(text, "\n", "", -1)
+	return text, nil
+}
+
+// This function creates a simple buffer input and reads a value from teh command line.
+func readStdinUint(prompt string, errorMessage string, intSize int) (uint64, error) {
+
+	text, err := readStdinLine(prompt)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("%s  Error: %s", errorMessage, err))
+	}
 	uintValue, err := strconv.ParseUint(text, 10, intSize)
 	if err != nil {
-		fmt.Println(errorMessage, "      read = ", text, "    err = ", err)
-		return 0, errors.New(fmt.Sprintf(errorMessage, "  Error: %s", err))
+		return 0, errors.New(fmt.Sprintf("%s  Error: %s", errorMessage, err))
 	}
 
 	return uintValue, nil
 }
 
 
+// The file holds a signed commit and reveal, so only the owner may read it
 func WriteToFile(fileName string, input string) (numberBytes int, err error) {
-	f, err := os.Create(fileName)
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if (err != nil) {
 		return 0, errors.New("Could not open output file.")
 	}
-	w := bufio.NewWriter(f)
-
 	defer f.Close()
+	// An older file may have been created with wider permissions
+	if err := f.Chmod(0600); err != nil {
+		return 0, errors.New("Could not set the output file permissions.")
+	}
+	w := bufio.NewWriter(f)
 
 	numberBytes, err = w.WriteString(input)
 	if (err != nil) {
@@ -51,4 +65,22 @@ func WriteToFile(fileName string, input string) (numberBytes int, err error) {
 	w.Flush()
 
 	return numberBytes, nil
-}
\ No newline at end of file
+}
+
+// Appends v to an audit file as one JSON line and syncs it.  The file is
+// only ever appended to.
+func appendJSONLine(fileName string, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not open %s: %s", fileName, err))
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
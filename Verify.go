@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/fer-api/internal/entrybinary"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// "fer-api verify" checks an FER entry made by someone else: that it is on
+// the FER chain, that its content is an FEREntry and that ExtIDs[0] is a
+// signature of the content by -public-key.  It reads a reveal-entry request,
+// a change-price response, a curl file like FERComposeCurls.dat or the entry
+// as hex, from a file, the argument itself or stdin.  Nothing is trusted but
+// the public key given.
+func runVerifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	publicKeyHex := flags.String("public-key", "", "hex ed25519 public key the entry must be signed with")
+	flags.Parse(args)
+
+	publicKeyBytes, err := hex.DecodeString(*publicKeyHex)
+	if err != nil || len(publicKeyBytes) != ed.PublicKeySize {
+		return errors.New("-public-key must be a 32 byte public key in hex")
+	}
+	var publicKey [ed.PublicKeySize]byte
+	copy(publicKey[:], publicKeyBytes)
+
+	input, err := readCommandInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	b, err := findEntry(string(input))
+	if err != nil {
+		return err
+	}
+	e, err := entrybinary.Unmarshal(b)
+	if err != nil {
+		return err
+	}
+	ok := printEntryChecks(e, b, &publicKey)
+
+	// A curl file or change-price response has the commit too
+	if commit, err := findStructuredParam(string(input), "message"); err == nil {
+		c, err := decodeCommit(commit)
+		if err != nil {
+			return err
+		}
+		if err := c.checkReveal(b); err != nil {
+			return err
+		}
+		fmt.Println("\nCommit:")
+		printCommit(c)
+		ok = ok && c.valid()
+	}
+	if !ok {
+		return errors.New("The entry does not verify")
+	}
+	fmt.Println("\nOK")
+	return nil
+}
+
+func fileExists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && !fi.IsDir()
+}
+
+var curlBody = regexp.MustCompile(`-d '([^']*)'`)
+
+// The entry, in factom.Entry.MarshalBinary form, from any of the inputs
+// verify takes.
+func findEntry(input string) ([]byte, error) {
+	b, err := findRequestParam(input, "entry")
+	if err != nil {
+		return nil, errors.New("No entry found: give a reveal-entry request, a curl file or the entry as hex")
+	}
+	return b, nil
+}
+
+// The hex param name ("entry" of a reveal, "message" of a commit) from hex,
+// a JSON-RPC request, a change-price response or a curl file.
+func findRequestParam(input string, name string) ([]byte, error) {
+	if b, err := hex.DecodeString(strings.TrimSpace(input)); err == nil && len(b) > 0 {
+		return b, nil
+	}
+	return findStructuredParam(input, name)
+}
+
+// Like findRequestParam but without the bare hex, for a second param looked
+// for in the same input: hex is only ever the one the caller asked for.
+func findStructuredParam(input string, name string) ([]byte, error) {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, "{") {
+		// A change-price result, alone or in its JSON-RPC response
+		var response struct {
+			addressResponse
+			Result addressResponse `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(input), &response); err == nil {
+			for _, r := range []addressResponse{response.Result, response.addressResponse} {
+				if name == "entry" && r.RevealJson != "" {
+					return jsonRPCHexParam(r.RevealJson, name)
+				}
+				if name == "message" && r.EntryCommitJson != "" {
+					return jsonRPCHexParam(r.EntryCommitJson, name)
+				}
+			}
+		}
+		return jsonRPCHexParam(input, name)
+	}
+	for _, m := range curlBody.FindAllStringSubmatch(input, -1) {
+		if b, err := jsonRPCHexParam(m[1], name); err == nil {
+			return b, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("No %s found", name))
+}
+
+// Prints every field of the entry and the result of each check.  It returns
+// whether all checks passed.
+func printEntryChecks(e *factom.Entry, b []byte, publicKey *[ed.PublicKeySize]byte) bool {
+	ok := true
+	check := func(passed bool, good string, bad string) string {
+		if passed {
+			return good
+		}
+		ok = false
+		return "FAIL: " + bad
+	}
+
+	fmt.Printf("Entry hash:         %x\n", entryHash(b))
+	fmt.Printf("Chain ID:           %s  %s\n", e.ChainID, check(e.ChainID == FERChainID, "(the FER chain)", "not the FER chain"))
+	for i, id := range e.ExtIDs {
+		fmt.Printf("ExtID %d:            %x\n", i, id)
+	}
+	fmt.Printf("Public key:         %x\n", publicKey[:])
+	fmt.Printf("Signature:          %s\n", check(signedBy(publicKey, e), "valid", "ExtID 0 is not a signature of the content by the public key"))
+	fmt.Printf("Content:            %s\n", e.Content)
+
+	fer, err := decodeFEREntry(e.Content)
+	if err != nil {
+		fmt.Printf("FEREntry:           %s\n", check(false, "", err.Error()))
+		return false
+	}
+	fmt.Printf("Version:            %s\n", fer.Version)
+	fmt.Printf("Expiration height:  %d\n", fer.ExpirationHeight)
+	fmt.Printf("Activation height:  %d\n", fer.TargetActivationHeight)
+	fmt.Printf("Priority:           %d\n", fer.Priority)
+	if fer.ReasonCode != "" {
+		fmt.Printf("Reason code:        %s\n", fer.ReasonCode)
+	}
+	if fer.Proposer != "" {
+		fmt.Printf("Proposer:           %s\n", fer.Proposer)
+	}
+	if fer.ProposalRef != "" {
+		fmt.Printf("Proposal:           %s\n", fer.ProposalRef)
+	}
+	fmt.Printf("Target price:       %d factoshis per EC\n", fer.TargetPrice)
+	if fer.TargetPrice != 0 {
+		fmt.Printf("Implied FCT price:  $%.2f\n", 100000/float64(fer.TargetPrice))
+	} else {
+		fmt.Printf("Implied FCT price:  %s\n", check(false, "", "a target price of 0"))
+	}
+	return ok
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"github.com/FactomProject/goleveldb/leveldb/util"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhooks POST events to operator URLs.  Every event a webhook wants is put
+// in the outbox (see Events.go) when it happens, and the delivery loop sends
+// it until the URL answers 2xx, backing off between attempts.  Delivery is at
+// least once; X-FER-Delivery identifies a delivery across retries.
+//
+// The body is the Event as JSON.  It is signed with the webhook's Secret:
+//
+//	X-FER-Timestamp: <unix seconds>
+//	X-FER-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// so a receiver can check both the body and that it isn't an old one replayed.
+type WebhookConfig struct {
+	Name   string
+	URL    string
+	Secret string
+	// Event types to send; the lifecycle events when empty.  chain-entry and
+	// rate-changed are only sent when listed.
+	Events  []string
+	Timeout string
+}
+
+func knownEventType(eventType string) bool {
+	switch eventType {
+	case EventComposed, EventCommitAccepted, EventRevealAccepted, EventAcknowledged, EventConfirmed,
+		EventActivated, EventExpired, EventPolicyRejected, EventChainEntry, EventRateChanged:
+		return true
+	}
+	return false
+}
+
+func (w WebhookConfig) wants(eventType string) bool {
+	if len(w.Events) == 0 {
+		return eventType != EventChainEntry && eventType != EventRateChanged
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (w WebhookConfig) GetTimeout() time.Duration {
+	d, err := time.ParseDuration(w.Timeout)
+	if err != nil || d <= 0 {
+		return 10 * time.Second
+	}
+	return d
+}
+
+const (
+	webhookMaxAttempts = 15
+	webhookMaxBackoff  = time.Hour
+)
+
+type webhookDelivery struct {
+	EventID     uint64    `json:"event-id"`
+	Webhook     string    `json:"webhook"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next-attempt"`
+	LastError   string    `json:"last-error,omitempty"`
+}
+
+func (d *webhookDelivery) marshal() []byte {
+	body, _ := json.Marshal(d)
+	return body
+}
+
+// 5s, 10s, 20s, ... up to an hour
+func webhookBackoff(attempts int) time.Duration {
+	backoff := 5 * time.Second
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+	return backoff
+}
+
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func runWebhookDelivery(interval time.Duration) {
+	for {
+		if entryStore != nil {
+			if err := entryStore.deliverWebhooks(time.Now().UTC()); err != nil {
+				logger.Warn("Webhook delivery pass failed", "component", "webhooks", "error", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Sends every delivery in the outbox that is due.
+func (s *EntryStore) deliverWebhooks(now time.Time) error {
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return err
+	}
+	hooks := make(map[string]WebhookConfig)
+	for _, hook := range config.Webhooks {
+		hooks[hook.Name] = hook
+	}
+
+	type pending struct {
+		key      []byte
+		delivery *webhookDelivery
+	}
+	due := make([]pending, 0)
+	iter := s.db.NewIterator(util.BytesPrefix([]byte("o:")), nil)
+	for iter.Next() {
+		d := new(webhookDelivery)
+		if err := json.Unmarshal(iter.Value(), d); err != nil {
+			continue
+		}
+		if !d.NextAttempt.After(now) {
+			due = append(due, pending{append([]byte{}, iter.Key()...), d})
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	for _, p := range due {
+		d := p.delivery
+		log := logger.With("component", "webhooks", "webhook", d.Webhook, "event_id", d.EventID)
+		hook, ok := hooks[d.Webhook]
+		if !ok {
+			log.Warn("Dropping delivery for a webhook no longer configured")
+			s.db.Delete(p.key, nil)
+			continue
+		}
+		event, err := s.getEvent(d.EventID)
+		if err != nil {
+			log.Error("Dropping delivery of a missing event", "error", err)
+			s.db.Delete(p.key, nil)
+			continue
+		}
+
+		err = postWebhook(hook, event, d)
+		if err == nil {
+			s.db.Delete(p.key, nil)
+			continue
+		}
+		d.Attempts++
+		d.LastError = err.Error()
+		if d.Attempts >= webhookMaxAttempts {
+			log.Error("Giving up on webhook delivery", "attempts", d.Attempts, "error", err)
+			s.db.Delete(p.key, nil)
+			continue
+		}
+		d.NextAttempt = now.Add(webhookBackoff(d.Attempts))
+		log.Warn("Webhook delivery failed, will retry", "attempts", d.Attempts, "next_attempt", d.NextAttempt, "error", err)
+		if err := s.db.Put(p.key, d.marshal(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func postWebhook(hook WebhookConfig, event *Event, d *webhookDelivery) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-FER-Event", event.Type)
+	req.Header.Set("X-FER-Delivery", fmt.Sprintf("%d-%s", event.ID, hook.Name))
+	req.Header.Set("X-FER-Timestamp", timestamp)
+	req.Header.Set("X-FER-Signature", signWebhook(hook.Secret, timestamp, body))
+
+	client := &http.Client{Timeout: hook.GetTimeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("%s answered %s", hook.URL, resp.Status))
+	}
+	return nil
+}
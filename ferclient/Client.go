@@ -0,0 +1,284 @@
+// Package ferclient calls the fer-api JSON-RPC API with typed params and
+// results:
+//
+//	c := ferclient.NewClient("http://localhost:9999/v2")
+//	entry, err := c.ChangePrice(ctx, ferclient.ChangeRequest{
+//		ExpirationHeight: "1004", ActivationHeight: "1003", Priority: "1", NewPricePerEC: "5000",
+//	})
+//
+// Every call has a timeout per try.  Calls that change nothing on the server
+// are tried again after an error in getting there or a 5xx or 429 answer;
+// JSON-RPC errors are returned as they are.
+package ferclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type Client struct {
+	// The JSON-RPC endpoint, e.g. http://localhost:9999/v2
+	URL        string
+	HTTPClient *http.Client
+	// Per try, 0 for none but the context's
+	Timeout time.Duration
+	// Tries after the first, for calls that change nothing
+	Retries int
+	// Before the first retry, doubled for each after it
+	RetryWait time.Duration
+
+	id int64
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		URL:        url,
+		HTTPClient: http.DefaultClient,
+		Timeout:    30 * time.Second,
+		Retries:    2,
+		RetryWait:  500 * time.Millisecond,
+	}
+}
+
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// Calls method and decodes its result into result.  idempotent calls are
+// retried.
+func (c *Client) call(ctx context.Context, method string, params interface{}, result interface{}, idempotent bool) error {
+	body, err := json.Marshal(request{JSONRPC: "2.0", ID: atomic.AddInt64(&c.id, 1), Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	tries := 1
+	if idempotent {
+		tries += c.Retries
+	}
+	wait := c.RetryWait
+	var raw json.RawMessage
+	for try := 1; ; try++ {
+		raw, err = c.post(ctx, body)
+		if err == nil || try >= tries || !retryable(err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return errors.New(fmt.Sprintf("Bad %s result: %s", method, err))
+	}
+	return nil
+}
+
+// One try
+func (c *Client) post(ctx context.Context, body []byte) (json.RawMessage, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// fer-api answers JSON-RPC errors with a 400
+	r := new(response)
+	if err := json.Unmarshal(b, r); err != nil || (r.Error == nil && resp.StatusCode != http.StatusOK) {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if r.Error != nil {
+		return nil, typedError(r.Error)
+	}
+	return r.Result, nil
+}
+
+// ChangePrice composes a signed FER entry; the server keeps it in its entry
+// store.
+func (c *Client) ChangePrice(ctx context.Context, req ChangeRequest) (*ComposedEntry, error) {
+	r := new(ComposedEntry)
+	if err := c.call(ctx, "change-price", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) ProposeFERChange(ctx context.Context, req ProposeRequest) (*Proposal, error) {
+	r := new(Proposal)
+	if err := c.call(ctx, "propose-fer-change", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) ApproveProposal(ctx context.Context, req VoteRequest) (*Proposal, error) {
+	r := new(Proposal)
+	if err := c.call(ctx, "approve-proposal", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) RejectProposal(ctx context.Context, req VoteRequest) (*Proposal, error) {
+	r := new(Proposal)
+	if err := c.call(ctx, "reject-proposal", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListProposals lists the proposals with status, or all for "".
+func (c *Client) ListProposals(ctx context.Context, status string) ([]*Proposal, error) {
+	var r []*Proposal
+	if err := c.call(ctx, "list-proposals", map[string]string{"status": status}, &r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) PrepareFEREntry(ctx context.Context, req ChangeRequest) (*PreparedEntry, error) {
+	r := new(PreparedEntry)
+	if err := c.call(ctx, "prepare-fer-entry", req, r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) FinalizeFEREntry(ctx context.Context, req FinalizeRequest) (*ComposedEntry, error) {
+	r := new(ComposedEntry)
+	if err := c.call(ctx, "finalize-fer-entry", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) ScheduleFERChange(ctx context.Context, req ScheduleRequest) (*ScheduledChange, error) {
+	r := new(ScheduledChange)
+	if err := c.call(ctx, "schedule-fer-change", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// RescheduleFERChange replaces the change req.ID.
+func (c *Client) RescheduleFERChange(ctx context.Context, req ScheduleRequest) (*ScheduledChange, error) {
+	r := new(ScheduledChange)
+	if err := c.call(ctx, "reschedule-fer-change", req, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) CancelScheduledChange(ctx context.Context, id string) (*ScheduledChange, error) {
+	r := new(ScheduledChange)
+	if err := c.call(ctx, "cancel-scheduled-change", map[string]string{"id": id}, r, false); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListScheduledChanges lists the changes with status, or all for "".
+func (c *Client) ListScheduledChanges(ctx context.Context, status string) ([]*ScheduledChange, error) {
+	var r []*ScheduledChange
+	if err := c.call(ctx, "list-scheduled-changes", map[string]string{"status": status}, &r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListPriceDecisions returns the latest limit decisions, newest first; the
+// server's default for 0 is 50.
+func (c *Client) ListPriceDecisions(ctx context.Context, limit int) ([]*PriceDecision, error) {
+	var r []*PriceDecision
+	if err := c.call(ctx, "list-price-decisions", map[string]int{"limit": limit}, &r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) GetFEREntry(ctx context.Context, entryHash string) (*StoredEntry, error) {
+	r := new(StoredEntry)
+	if err := c.call(ctx, "get-fer-entry", map[string]string{"entry-hash": entryHash}, r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Client) ListLocalFEREntries(ctx context.Context, filter EntryFilter) ([]*StoredEntry, error) {
+	var r []*StoredEntry
+	if err := c.call(ctx, "list-local-fer-entries", filter, &r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetRateHistory gives the rate changes from one height to another; a to of
+// 0 is the directory block height.
+func (c *Client) GetRateHistory(ctx context.Context, from int64, to int64) (*RateHistory, error) {
+	r := new(RateHistory)
+	params := map[string]interface{}{"from-height": from, "to-height": to}
+	if err := c.call(ctx, "get-rate-history", params, r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetRateHistoryCSV is GetRateHistory as CSV.
+func (c *Client) GetRateHistoryCSV(ctx context.Context, from int64, to int64) (string, error) {
+	var r string
+	params := map[string]interface{}{"from-height": from, "to-height": to, "format": "csv"}
+	if err := c.call(ctx, "get-rate-history", params, &r, true); err != nil {
+		return "", err
+	}
+	return r, nil
+}
+
+func (c *Client) DecodeCommit(ctx context.Context, req DecodeCommitRequest) (*CommitInfo, error) {
+	r := new(CommitInfo)
+	if err := c.call(ctx, "decode-commit", req, r, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
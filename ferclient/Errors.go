@@ -0,0 +1,79 @@
+package ferclient
+
+import "fmt"
+
+// JSON-RPC error codes sent by fer-api
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// RPCError is a JSON-RPC error from the server.  Calls return it as the
+// type for its code, each of which holds an RPCError:
+//
+//	switch err.(type) {
+//	case *ferclient.InvalidParamsError:
+//	case *ferclient.InternalError:
+//	}
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	if e.Data != nil {
+		return fmt.Sprintf("%s (%d): %v", e.Message, e.Code, e.Data)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// The request was not JSON-RPC 2.0, or its params were not the method's
+// (change-price answers a bad body with this).
+type InvalidRequestError struct{ RPCError }
+
+type MethodNotFoundError struct{ RPCError }
+
+type InvalidParamsError struct{ RPCError }
+
+// The server could not do what was asked; Data says why, e.g. an approval
+// policy, a bad signature or factomd being down.
+type InternalError struct{ RPCError }
+
+// HTTPError is an answer that wasn't JSON-RPC, e.g. from a proxy.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+func typedError(e *RPCError) error {
+	switch e.Code {
+	case CodeInvalidRequest, CodeParseError:
+		return &InvalidRequestError{*e}
+	case CodeMethodNotFound:
+		return &MethodNotFoundError{*e}
+	case CodeInvalidParams:
+		return &InvalidParamsError{*e}
+	case CodeInternalError:
+		return &InternalError{*e}
+	}
+	return e
+}
+
+// Worth another try: the server may not have seen the request.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *RPCError, *InvalidRequestError, *MethodNotFoundError, *InvalidParamsError, *InternalError:
+		return false
+	case *HTTPError:
+		return e.StatusCode >= 500 || e.StatusCode == 429
+	}
+	return true
+}
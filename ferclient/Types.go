@@ -0,0 +1,258 @@
+package ferclient
+
+import "time"
+
+// The params and results of the fer-api JSON-RPC methods, as the server
+// sends them.  Heights and prices in requests are strings, as in the README.
+
+// Params of change-price and prepare-fer-entry
+type ChangeRequest struct {
+	ExpirationHeight string `json:"expiration-height"`
+	ActivationHeight string `json:"activation-height"`
+	Priority         string `json:"priority"`
+	NewPricePerEC    string `json:"new-price-per-EC"`
+	// jsonrpc (default), curl, raw or factom-cli
+	Format string `json:"format,omitempty"`
+	// Unix milliseconds for the commit, only on a server run with -deterministic
+	CommitTimestamp string `json:"commit-timestamp,omitempty"`
+	// Only on a server with Version = "2.0"
+	ReasonCode  string `json:"reason-code,omitempty"`
+	Proposer    string `json:"proposer,omitempty"`
+	ProposalRef string `json:"proposal-ref,omitempty"`
+}
+
+const (
+	FormatJSONRPC   = "jsonrpc"
+	FormatCurl      = "curl"
+	FormatRaw       = "raw"
+	FormatFactomCLI = "factom-cli"
+)
+
+// A composed entry: the factomd commit-entry and reveal-entry requests, and
+// the fields of the format asked for.
+type ComposedEntry struct {
+	EntryCommit          string  `json:"entry-commit"`
+	RevealEntry          string  `json:"reveal-entry"`
+	TargetPriceInDollars float64 `json:"target-price-in-dollars"`
+	ECAddress            string  `json:"ec-address"`
+	Format               string  `json:"format,omitempty"`
+	Output               string  `json:"output,omitempty"`
+	CommitMessage        string  `json:"commit-message,omitempty"`
+	RevealMessage        string  `json:"reveal-message,omitempty"`
+}
+
+type ProposeRequest struct {
+	ChangeRequest
+	Reason string `json:"reason"`
+	// Send the entry to factomd once approved
+	Submit bool `json:"submit"`
+}
+
+// Params of approve-proposal and reject-proposal.  The signature is of
+// "approve-proposal:<id>" or "reject-proposal:<id>" by the approver key.
+type VoteRequest struct {
+	ProposalID string `json:"proposal-id"`
+	Approver   string `json:"approver"`
+	Signature  string `json:"signature"`
+}
+
+const (
+	ProposalPending  = "pending"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+	ProposalExpired  = "expired"
+	ProposalFailed   = "failed"
+)
+
+type ProposalVote struct {
+	Approver  string    `json:"approver"`
+	Signature string    `json:"signature"`
+	Time      time.Time `json:"time"`
+}
+
+type Proposal struct {
+	ID string `json:"id"`
+	ChangeRequest
+	Reason     string         `json:"reason"`
+	Submit     bool           `json:"submit"`
+	Status     string         `json:"status"`
+	Approvals  []ProposalVote `json:"approvals"`
+	Rejections []ProposalVote `json:"rejections"`
+	CreatedAt  time.Time      `json:"created-at"`
+	ExpiresAt  time.Time      `json:"expires-at"`
+	Result     *ComposedEntry `json:"result,omitempty"`
+	TxID       string         `json:"txid,omitempty"`
+	EntryHash  string         `json:"entry-hash,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Result of prepare-fer-entry, for signing offline
+type PreparedEntry struct {
+	Content              string  `json:"content"`
+	ContentHex           string  `json:"content-hex"`
+	SigningPublicKey     string  `json:"signing-public-key"`
+	TargetPriceInDollars float64 `json:"target-price-in-dollars"`
+}
+
+type FinalizeRequest struct {
+	ContentHex      string `json:"content-hex"`
+	Signature       string `json:"signature"`
+	Format          string `json:"format,omitempty"`
+	CommitTimestamp string `json:"commit-timestamp,omitempty"`
+}
+
+// Params of schedule-fer-change and, with ID, reschedule-fer-change.  Give
+// ActivationHeight or ActivateAt.
+type ScheduleRequest struct {
+	ID               string     `json:"id,omitempty"`
+	ActivationHeight string     `json:"activation-height,omitempty"`
+	ActivateAt       *time.Time `json:"activate-at,omitempty"`
+	Priority         string     `json:"priority"`
+	NewPricePerEC    string     `json:"new-price-per-EC"`
+}
+
+const (
+	ScheduleScheduled  = "scheduled"
+	ScheduleSubmitting = "submitting"
+	ScheduleSubmitted  = "submitted"
+	ScheduleFailed     = "failed"
+	ScheduleMissed     = "missed"
+	ScheduleCancelled  = "cancelled"
+)
+
+type ScheduledChange struct {
+	ID               string         `json:"id"`
+	ActivationHeight uint32         `json:"activation-height,omitempty"`
+	ActivateAt       *time.Time     `json:"activate-at,omitempty"`
+	Priority         string         `json:"priority"`
+	NewPricePerEC    string         `json:"new-price-per-EC"`
+	Status           string         `json:"status"`
+	CreatedAt        time.Time      `json:"created-at"`
+	UpdatedAt        time.Time      `json:"updated-at"`
+	Result           *ComposedEntry `json:"result,omitempty"`
+	TxID             string         `json:"txid,omitempty"`
+	EntryHash        string         `json:"entry-hash,omitempty"`
+	Error            string         `json:"error,omitempty"`
+}
+
+type SourceReading struct {
+	Name   string    `json:"name"`
+	Price  float64   `json:"price,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// One pass of the price feed
+type PriceDecision struct {
+	Time              time.Time       `json:"time"`
+	Sources           []SourceReading `json:"sources"`
+	Aggregate         string          `json:"aggregate"`
+	Quorum            int             `json:"quorum"`
+	Agreeing          int             `json:"agreeing"`
+	Price             float64         `json:"price,omitempty"`
+	LeaderHeight      int64           `json:"leader-height,omitempty"`
+	CurrentRate       uint64          `json:"current-rate,omitempty"`
+	WantedTargetPrice uint64          `json:"wanted-target-price,omitempty"`
+	TargetPrice       uint64          `json:"target-price,omitempty"`
+	ActivationHeight  int64           `json:"activation-height,omitempty"`
+	Action            string          `json:"action"`
+	Reason            string          `json:"reason,omitempty"`
+	EntryHash         string          `json:"entry-hash,omitempty"`
+	TxID              string          `json:"txid,omitempty"`
+}
+
+// The content of an FER chain entry
+type FEREntry struct {
+	ExpirationHeight       uint32 `json:"expiration_height"`
+	TargetActivationHeight uint32 `json:"target_activation_height"`
+	Priority               uint32 `json:"priority"`
+	TargetPrice            uint64 `json:"target_price"`
+	Version                string `json:"version"`
+	// Version 2.0 only
+	ReasonCode  string `json:"reason_code,omitempty"`
+	Proposer    string `json:"proposer,omitempty"`
+	ProposalRef string `json:"proposal_ref,omitempty"`
+}
+
+const (
+	EntryComposed     = "composed"
+	EntrySubmitted    = "submitted"
+	EntryFailed       = "failed"
+	EntryAcknowledged = "acknowledged"
+	EntryConfirmed    = "confirmed"
+	EntryActivated    = "activated"
+	EntryExpired      = "expired"
+)
+
+// An entry from the server's entry store
+type StoredEntry struct {
+	EntryHash   string               `json:"entry-hash"`
+	FEREntry    FEREntry             `json:"fer-entry"`
+	Content     string               `json:"content"`
+	Signature   string               `json:"signature"`
+	EntryCommit string               `json:"entry-commit"`
+	RevealEntry string               `json:"reveal-entry"`
+	ECAddress   string               `json:"ec-address"`
+	TxID        string               `json:"txid,omitempty"`
+	ProposalID  string               `json:"proposal-id,omitempty"`
+	State       string               `json:"state"`
+	Error       string               `json:"error,omitempty"`
+	CreatedAt   time.Time            `json:"created-at"`
+	UpdatedAt   time.Time            `json:"updated-at"`
+	StateTimes  map[string]time.Time `json:"state-times"`
+}
+
+// Params of list-local-fer-entries; zero fields don't filter
+type EntryFilter struct {
+	ProposalID           string `json:"proposal-id,omitempty"`
+	FromActivationHeight uint32 `json:"from-activation-height,omitempty"`
+	ToActivationHeight   uint32 `json:"to-activation-height,omitempty"`
+	State                string `json:"state,omitempty"`
+	Limit                int    `json:"limit,omitempty"`
+}
+
+type RateHistory struct {
+	FromHeight int64         `json:"from-height"`
+	ToHeight   int64         `json:"to-height"`
+	Changes    []*RateChange `json:"changes"`
+}
+
+type RateChange struct {
+	Height       int64      `json:"height"`
+	Rate         uint64     `json:"rate"`
+	PreviousRate uint64     `json:"previous-rate,omitempty"`
+	Cause        *RateCause `json:"cause,omitempty"`
+}
+
+// The FER chain entry that asked for a rate change
+type RateCause struct {
+	EntryHash   string   `json:"entry-hash"`
+	EntryHeight int64    `json:"entry-height"`
+	FEREntry    FEREntry `json:"fer-entry"`
+	// Set when the content doesn't fit the schema of its version
+	SchemaError string `json:"schema-error,omitempty"`
+}
+
+type DecodeCommitRequest struct {
+	// The commit-entry request or its hex message
+	EntryCommit string `json:"entry-commit"`
+	// Optional, the reveal-entry request or the entry hex
+	RevealEntry string `json:"reveal-entry,omitempty"`
+}
+
+type CommitInfo struct {
+	Version        byte      `json:"version"`
+	Milliseconds   int64     `json:"milliseconds"`
+	Time           time.Time `json:"time"`
+	EntryHash      string    `json:"entry-hash"`
+	ECCost         byte      `json:"ec-cost"`
+	ECPublicKey    string    `json:"ec-public-key"`
+	ECAddress      string    `json:"ec-address"`
+	Signature      string    `json:"signature"`
+	SignatureValid bool      `json:"signature-valid"`
+	// Set when checked against the reveal
+	RevealEntryHash string `json:"reveal-entry-hash,omitempty"`
+	RevealECCost    byte   `json:"reveal-ec-cost,omitempty"`
+	MatchesReveal   *bool  `json:"matches-reveal,omitempty"`
+}
@@ -0,0 +1,76 @@
+package fertest
+
+import (
+	"encoding/json"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+)
+
+// factomd takes an FER entry in block h when:
+//
+//	ExtIDs[0] is a signature of the content by the authority key
+//	the content is an FEREntry
+//	h <= expiration_height <= h + MaxExpirationDistance
+//	target_activation_height > h
+//	no change is pending, or the entry has a higher priority than it
+//
+// The pending change then becomes the entry's, and the rate is set to its
+// target_price in block target_activation_height.
+const MaxExpirationDistance = 12
+
+// The entry content, as fer-api's FEREntry
+type FEREntry struct {
+	ExpirationHeight       uint32 `json:"expiration_height"`
+	TargetActivationHeight uint32 `json:"target_activation_height"`
+	Priority               uint32 `json:"priority"`
+	TargetPrice            uint64 `json:"target_price"`
+	Version                string `json:"version"`
+}
+
+type ferState struct {
+	// -1 when no change is pending
+	changeHeight int64
+	changePrice  uint64
+	priority     uint32
+}
+
+func (f *Factomd) takeFEREntries(h int64, entries []*blockEntry) {
+	for _, be := range entries {
+		fer, ok := f.ferEntry(be.entry)
+		if !ok {
+			continue
+		}
+		expiration, activation := int64(fer.ExpirationHeight), int64(fer.TargetActivationHeight)
+		if expiration < h || expiration > h+MaxExpirationDistance || activation <= h {
+			continue
+		}
+		if f.fer.changeHeight >= 0 && fer.Priority <= f.fer.priority {
+			continue
+		}
+		f.fer = ferState{changeHeight: activation, changePrice: fer.TargetPrice, priority: fer.Priority}
+	}
+}
+
+func (f *Factomd) applyRateChange(h int64) {
+	if f.fer.changeHeight == h {
+		f.rate = f.fer.changePrice
+		f.fer = ferState{changeHeight: -1}
+	}
+}
+
+// The FEREntry of e, if it is one signed by the authority
+func (f *Factomd) ferEntry(e *factom.Entry) (*FEREntry, bool) {
+	if len(e.ExtIDs) == 0 || len(e.ExtIDs[0]) != ed.SignatureSize {
+		return nil, false
+	}
+	var sig [ed.SignatureSize]byte
+	copy(sig[:], e.ExtIDs[0])
+	if !ed.Verify(&f.authority, e.Content, &sig) {
+		return nil, false
+	}
+	fer := new(FEREntry)
+	if err := json.Unmarshal(e.Content, fer); err != nil {
+		return nil, false
+	}
+	return fer, true
+}
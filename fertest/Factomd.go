@@ -0,0 +1,465 @@
+// Package fertest is an in-process stand-in for factomd's v2 API, for
+// integration tests of fer-api and its clients.  It takes commits and
+// reveals, builds blocks only when told to, and changes the EC rate from
+// FER chain entries by factomd's rules (see FER.go):
+//
+//	f, err := fertest.NewFactomd(signingPublicKey, 1000)
+//	defer f.Close()
+//	factom.SetFactomdServer(f.Addr())
+//	f.SetECBalance(ecAddress, 100)
+//	... submit an FER entry ...
+//	f.AdvanceBlocks(3)
+//	f.Rate()
+package fertest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	ed "github.com/FactomProject/ed25519"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/fer-api/internal/entrybinary"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The FER chain and the entry that made it
+const FERChainID = "111111118d918a8be684e0dac725493a75862ef96d2d3f43f84b26969329bf03"
+
+var ferChainEntry = &factom.Entry{
+	ChainID: FERChainID,
+	ExtIDs:  [][]byte{[]byte("FCT EC Conversion Rate Chain"), []byte("1950454129")},
+	Content: []byte("This chain contains messages which coordinate the FCT to EC conversion rate amongst factomd nodes."),
+}
+
+// factomd's JSON-RPC error codes
+const (
+	codeMethodNotFound   = -32601
+	codeInvalidParams    = -32602
+	codeInternalError    = -32603
+	codeBlockNotFound    = -32008
+	codeMissingChainHead = -32009
+)
+
+// Factomd serves the factomd v2 methods fer-api uses: heights, commit-entry,
+// reveal-entry, entry-ack, chain-head, entry-block, entry,
+// entry-credit-balance, entry-credit-rate and factoid-block-by-height (sent
+// as fblock-by-height by the factom library).  Block 0 holds the entry that
+// made the FER chain.
+type Factomd struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	height    int64
+	rate      uint64
+	authority [ed.PublicKeySize]byte
+	fer       ferState
+	// Entry credits by EC address
+	balances map[string]int64
+	// By entry hash
+	commits map[string]*commit
+	entries map[string]*blockEntry
+	// Revealed, for the next block
+	pending []*blockEntry
+	// Entry blocks by key MR, and the key MR of each chain's last one
+	eblocks map[string]*factom.EBlock
+	heads   map[string]string
+	// The rate of each factoid block, by height
+	rates []uint64
+}
+
+type commit struct {
+	txID     string
+	ecCost   int8
+	revealed bool
+}
+
+type blockEntry struct {
+	entry *factom.Entry
+	hash  string
+	txID  string
+	// -1 until it is in a block
+	height int64
+}
+
+// NewFactomd starts a fake factomd at block 0 with the EC rate, taking FER
+// entries signed by authorityKey (the hex ed25519 public key of
+// ExchangeRateAuthorityPublicKey).
+func NewFactomd(authorityKey string, rate uint64) (*Factomd, error) {
+	key, err := hex.DecodeString(authorityKey)
+	if err != nil || len(key) != ed.PublicKeySize {
+		return nil, errors.New(fmt.Sprintf("Invalid authority public key: %s", authorityKey))
+	}
+	f := &Factomd{
+		height:   -1,
+		rate:     rate,
+		fer:      ferState{changeHeight: -1},
+		balances: make(map[string]int64),
+		commits:  make(map[string]*commit),
+		entries:  make(map[string]*blockEntry),
+		eblocks:  make(map[string]*factom.EBlock),
+		heads:    make(map[string]string),
+	}
+	copy(f.authority[:], key)
+	f.addEntry(ferChainEntry, "")
+	f.completeBlock()
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f, nil
+}
+
+// Addr is the host:port for factom.SetFactomdServer and FactomdServer.
+func (f *Factomd) Addr() string {
+	return strings.TrimPrefix(f.URL, "http://")
+}
+
+// Height is the directory block height; the leader is on the next block.
+func (f *Factomd) Height() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.height
+}
+
+// Rate is the EC rate of the last block, in factoshis per EC.
+func (f *Factomd) Rate() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rate
+}
+
+// RateAt is the EC rate of the factoid block at height.
+func (f *Factomd) RateAt(height int64) (uint64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if height < 0 || height >= int64(len(f.rates)) {
+		return 0, false
+	}
+	return f.rates[height], true
+}
+
+// PendingRateChange is the FER change waiting for its activation height.
+func (f *Factomd) PendingRateChange() (height int64, price uint64, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fer.changeHeight < 0 {
+		return 0, 0, false
+	}
+	return f.fer.changeHeight, f.fer.changePrice, true
+}
+
+func (f *Factomd) SetECBalance(ecAddress string, credits int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.balances[ecAddress] = credits
+}
+
+func (f *Factomd) ECBalance(ecAddress string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.balances[ecAddress]
+}
+
+// AddEntry puts e in the next block without a commit, e.g. an FER entry
+// made by another authority.  It returns the entry hash.
+func (f *Factomd) AddEntry(e *factom.Entry) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addEntry(e, "")
+}
+
+// AdvanceBlock completes the next block: the revealed entries go into entry
+// blocks, FER entries among them are taken and a rate change due at the
+// block is applied.  It returns the new height.
+func (f *Factomd) AdvanceBlock() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.completeBlock()
+}
+
+func (f *Factomd) AdvanceBlocks(n int) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.completeBlock()
+	}
+	return f.height
+}
+
+// AdvanceTo completes blocks until the directory block height is height.
+func (f *Factomd) AdvanceTo(height int64) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.height < height {
+		f.completeBlock()
+	}
+	return f.height
+}
+
+func (f *Factomd) addEntry(e *factom.Entry, txID string) string {
+	hash := hex.EncodeToString(e.Hash())
+	be := &blockEntry{entry: e, hash: hash, txID: txID, height: -1}
+	f.entries[hash] = be
+	f.pending = append(f.pending, be)
+	return hash
+}
+
+func (f *Factomd) completeBlock() int64 {
+	h := f.height + 1
+	now := time.Now().Unix()
+
+	var chains []string
+	byChain := make(map[string][]*blockEntry)
+	for _, be := range f.pending {
+		if _, ok := byChain[be.entry.ChainID]; !ok {
+			chains = append(chains, be.entry.ChainID)
+		}
+		byChain[be.entry.ChainID] = append(byChain[be.entry.ChainID], be)
+	}
+	f.pending = nil
+
+	for _, chainID := range chains {
+		eb := new(factom.EBlock)
+		eb.Header.ChainID = chainID
+		eb.Header.DBHeight = h
+		eb.Header.Timestamp = now
+		eb.Header.PrevKeyMR = factom.ZeroHash
+		if prev, ok := f.heads[chainID]; ok {
+			eb.Header.PrevKeyMR = prev
+			eb.Header.BlockSequenceNumber = f.eblocks[prev].Header.BlockSequenceNumber + 1
+		}
+		for _, be := range byChain[chainID] {
+			be.height = h
+			eb.EntryList = append(eb.EntryList, factom.EBEntry{EntryHash: be.hash, Timestamp: now})
+		}
+		keyMR := eblockKeyMR(eb)
+		f.eblocks[keyMR] = eb
+		f.heads[chainID] = keyMR
+	}
+
+	f.takeFEREntries(h, byChain[FERChainID])
+	f.applyRateChange(h)
+	f.rates = append(f.rates, f.rate)
+	f.height = h
+	return h
+}
+
+// Not factomd's merkle root, but unique to the block and its entries
+func eblockKeyMR(eb *factom.EBlock) string {
+	h := sha256.New()
+	h.Write([]byte(eb.Header.ChainID))
+	binary.Write(h, binary.BigEndian, eb.Header.DBHeight)
+	h.Write([]byte(eb.Header.PrevKeyMR))
+	for _, e := range eb.EntryList {
+		h.Write([]byte(e.EntryHash))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (f *Factomd) handle(w http.ResponseWriter, r *http.Request) {
+	resp := factom.NewJSON2Response()
+	req := new(factom.JSON2Request)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		resp.Error = factom.NewJSONError(-32700, "Parse error", nil)
+	} else {
+		resp.ID = req.ID
+		f.mu.Lock()
+		result, jsonErr := f.call(req.Method, req.Params)
+		f.mu.Unlock()
+		if jsonErr != nil {
+			resp.Error = jsonErr
+		} else if b, err := json.Marshal(result); err != nil {
+			resp.Error = factom.NewJSONError(codeInternalError, "Internal error", err.Error())
+		} else {
+			resp.Result = b
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func invalidParams() *factom.JSONError {
+	return factom.NewJSONError(codeInvalidParams, "Invalid params", nil)
+}
+
+func internalError(data string) *factom.JSONError {
+	return factom.NewJSONError(codeInternalError, "Internal error", data)
+}
+
+func (f *Factomd) call(method string, params json.RawMessage) (interface{}, *factom.JSONError) {
+	var p struct {
+		Message string `json:"message"`
+		Entry   string `json:"entry"`
+		TxID    string `json:"txid"`
+		ChainID string `json:"chainid"`
+		KeyMR   string `json:"keymr"`
+		Hash    string `json:"hash"`
+		Address string `json:"address"`
+		Height  *int64 `json:"height"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams()
+		}
+	}
+
+	switch method {
+	case "heights":
+		return factom.HeightsResponse{
+			DirectoryBlockHeight: f.height,
+			LeaderHeight:         f.height + 1,
+			EntryBlockHeight:     f.height,
+			EntryHeight:          f.height,
+		}, nil
+	case "commit-entry":
+		return f.commitEntry(p.Message)
+	case "reveal-entry":
+		return f.revealEntry(p.Entry)
+	case "entry-ack":
+		return f.entryAck(p.TxID), nil
+	case "chain-head":
+		return f.chainHead(p.ChainID)
+	case "entry-block":
+		eb, ok := f.eblocks[p.KeyMR]
+		if !ok {
+			return nil, factom.NewJSONError(codeBlockNotFound, "Block not found", nil)
+		}
+		return eb, nil
+	case "entry":
+		be, ok := f.entries[p.Hash]
+		if !ok {
+			return nil, factom.NewJSONError(codeBlockNotFound, "Entry not found", nil)
+		}
+		return be.entry, nil
+	case "entry-credit-balance":
+		return map[string]int64{"balance": f.balances[p.Address]}, nil
+	case "entry-credit-rate":
+		return map[string]uint64{"rate": f.rate}, nil
+	case "fblock-by-height", "factoid-block-by-height":
+		if p.Height == nil {
+			return nil, invalidParams()
+		}
+		if *p.Height < 0 || *p.Height > f.height {
+			return nil, factom.NewJSONError(codeBlockNotFound, "Block not found", nil)
+		}
+		return map[string]interface{}{
+			"fblock": map[string]interface{}{"dbheight": *p.Height, "exchrate": f.rates[*p.Height]},
+		}, nil
+	}
+	return nil, factom.NewJSONError(codeMethodNotFound, "Method not found", nil)
+}
+
+// An entry commit:
+//
+//	version 1, milliseconds 6, entry hash 32, EC cost 1, EC public key 32,
+//	signature 64 over the first 40 bytes
+func (f *Factomd) commitEntry(message string) (interface{}, *factom.JSONError) {
+	b, err := hex.DecodeString(message)
+	if err != nil || len(b) != 136 {
+		return nil, invalidParams()
+	}
+	var publicKey [ed.PublicKeySize]byte
+	var sig [ed.SignatureSize]byte
+	copy(publicKey[:], b[40:72])
+	copy(sig[:], b[72:])
+	if !ed.Verify(&publicKey, b[:40], &sig) {
+		return nil, internalError("invalid commit signature")
+	}
+	hash := hex.EncodeToString(b[7:39])
+	cost := int8(b[39])
+	if c, ok := f.commits[hash]; ok && !c.revealed {
+		return nil, internalError("repeated commit")
+	}
+
+	a := factom.NewECAddress()
+	*a.Pub = publicKey
+	address := a.PubString()
+	if f.balances[address] < int64(cost) {
+		return nil, internalError(fmt.Sprintf("%s has %d entry credits, the commit costs %d", address, f.balances[address], cost))
+	}
+	f.balances[address] -= int64(cost)
+
+	txID := sha256.Sum256(b[:40])
+	f.commits[hash] = &commit{txID: hex.EncodeToString(txID[:]), ecCost: cost}
+	return map[string]string{
+		"message":   "Entry Commit Success",
+		"txid":      hex.EncodeToString(txID[:]),
+		"entryhash": hash,
+	}, nil
+}
+
+func (f *Factomd) revealEntry(entryHex string) (interface{}, *factom.JSONError) {
+	b, err := hex.DecodeString(entryHex)
+	if err != nil {
+		return nil, invalidParams()
+	}
+	e, err := entrybinary.Unmarshal(b)
+	if err != nil {
+		return nil, invalidParams()
+	}
+	hash := hex.EncodeToString(e.Hash())
+	c, ok := f.commits[hash]
+	if !ok || c.revealed {
+		return nil, internalError("no commit for entry " + hash)
+	}
+	cost, err := factom.EntryCost(e)
+	if err != nil {
+		return nil, internalError(err.Error())
+	}
+	if c.ecCost < cost {
+		return nil, internalError(fmt.Sprintf("the commit paid %d entry credits, the entry costs %d", c.ecCost, cost))
+	}
+	if _, ok := f.heads[e.ChainID]; !ok {
+		return nil, internalError("chain " + e.ChainID + " does not exist")
+	}
+	c.revealed = true
+	f.addEntry(e, c.txID)
+	return map[string]string{
+		"message":   "Entry Reveal Success",
+		"entryhash": hash,
+		"chainid":   e.ChainID,
+	}, nil
+}
+
+// Statuses as factomd's: Unknown, TransactionACK once known and
+// DBlockConfirmed once in a block.  txid is an entry hash or commit txid.
+func (f *Factomd) entryAck(txID string) *factom.EntryStatus {
+	status := new(factom.EntryStatus)
+	status.CommitData.Status = "Unknown"
+	status.EntryData.Status = "Unknown"
+	hash := txID
+	for h, c := range f.commits {
+		if c.txID == txID {
+			hash = h
+		}
+	}
+	status.EntryHash = hash
+	if c, ok := f.commits[hash]; ok {
+		status.CommitTxID = c.txID
+		status.CommitData.Status = "TransactionACK"
+	}
+	if be, ok := f.entries[hash]; ok {
+		status.EntryData.Status = "TransactionACK"
+		if be.height >= 0 {
+			status.CommitData.Status = "DBlockConfirmed"
+			status.EntryData.Status = "DBlockConfirmed"
+		}
+	}
+	return status
+}
+
+func (f *Factomd) chainHead(chainID string) (interface{}, *factom.JSONError) {
+	head, ok := f.heads[chainID]
+	inProcess := false
+	for _, be := range f.pending {
+		inProcess = inProcess || be.entry.ChainID == chainID
+	}
+	if !ok && !inProcess {
+		return nil, factom.NewJSONError(codeMissingChainHead, "Missing Chain Head", nil)
+	}
+	return map[string]interface{}{"chainhead": head, "chaininprocesslist": inProcess}, nil
+}
@@ -0,0 +1,38 @@
+// Package entrybinary reads Factom entries in factom.Entry.MarshalBinary
+// form, for fer-api and its fertest stand-in for factomd.
+package entrybinary
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/FactomProject/factom"
+	"github.com/FactomProject/goleveldb/leveldb/errors"
+)
+
+// Unmarshal reads an entry in factom.Entry.MarshalBinary form.
+func Unmarshal(b []byte) (*factom.Entry, error) {
+	// version, chain id and the length of the ext ids
+	if len(b) < 35 {
+		return nil, errors.New("Entry is too short")
+	}
+	e := new(factom.Entry)
+	e.ChainID = hex.EncodeToString(b[1:33])
+	extLen := int(binary.BigEndian.Uint16(b[33:35]))
+	if len(b) < 35+extLen {
+		return nil, errors.New("Entry ext ids are cut short")
+	}
+	ext := b[35 : 35+extLen]
+	for len(ext) > 0 {
+		if len(ext) < 2 {
+			return nil, errors.New("Entry ext ids are malformed")
+		}
+		n := int(binary.BigEndian.Uint16(ext[:2]))
+		if len(ext) < 2+n {
+			return nil, errors.New("Entry ext ids are malformed")
+		}
+		e.ExtIDs = append(e.ExtIDs, ext[2:2+n])
+		ext = ext[2+n:]
+	}
+	e.Content = b[35+extLen:]
+	return e, nil
+}
@@ -11,11 +11,30 @@ import (
 	"github.com/FactomProject/factom"
 	"github.com/FactomProject/web"
 	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	webServer *web.Server
 	pflag     = flag.Int("p", 9999, "set the port to host the wsapi")
+	addrFlag  = flag.String("addr", "", "listen address, host:port or unix:/path/to.sock (overrides -p)")
+	confFlag  = flag.String("config", configFileName, "config file")
+
+	readTimeoutFlag      = flag.Duration("read-timeout", 10*time.Second, "time allowed to read a request")
+	writeTimeoutFlag     = flag.Duration("write-timeout", 60*time.Second, "time allowed to write a response")
+	idleTimeoutFlag      = flag.Duration("idle-timeout", 120*time.Second, "keep-alive idle time")
+	shutdownTimeoutFlag  = flag.Duration("shutdown-timeout", 60*time.Second, "time in-flight requests get to finish on SIGTERM")
+	maxBodyFlag          = flag.Int64("max-body", 1<<20, "request body size limit in bytes")
+	logLevelFlag         = flag.String("log-level", "info", "debug, info, warn or error")
+	metricsIntervalFlag  = flag.Duration("metrics-interval", 30*time.Second, "how often factomd is polled for the /metrics gauges")
+	scheduleIntervalFlag = flag.Duration("schedule-interval", time.Minute, "how often the scheduler checks for due changes")
+	trackIntervalFlag    = flag.Duration("track-interval", 30*time.Second, "how often submitted entries are checked for ack, confirmation and activation")
+	watchIntervalFlag    = flag.Duration("watch-interval", 10*time.Second, "how often the FER chain and the exchange rate are checked for /events")
+	deterministicFlag    = flag.Bool("deterministic", false, "accept a caller's commit-timestamp, for reproducible output in tests")
 )
 
 const httpBad = 400
@@ -36,24 +55,36 @@ func handleV2Error(ctx *web.Context, j *factom.JSON2Request, err *factom.JSONErr
 func newInvalidRequestError() *factom.JSONError {
 	return factom.NewJSONError(-32600, "Invalid Request", nil)
 }
+func newMethodNotFoundError() *factom.JSONError {
+	return factom.NewJSONError(-32601, "Method not found", nil)
+}
+func newInvalidParamsError() *factom.JSONError {
+	return factom.NewJSONError(-32602, "Invalid params", nil)
+}
 func newCustomInternalError(data interface{}) *factom.JSONError {
 	return factom.NewJSONError(-32603, "Internal error", data)
 }
 
 func handleV2(ctx *web.Context) {
+	id := requestID(ctx.Request.Header.Get("X-Request-ID"))
+	ctx.SetHeader("X-Request-ID", id, true)
+	reqLog := logger.With("request_id", id, "remote", ctx.Request.RemoteAddr)
+
 	body, err := ioutil.ReadAll(ctx.Request.Body)
 	if err != nil {
+		reqLog.Warn("Could not read request body", "error", err)
 		handleV2Error(ctx, nil, newInvalidRequestError())
 		return
 	}
 
 	j, err := factom.ParseJSON2Request(string(body))
 	if err != nil {
+		reqLog.Warn("Invalid JSON-RPC request", "error", err)
 		handleV2Error(ctx, nil, newInvalidRequestError())
 		return
 	}
 
-	jsonResp, jsonError := handleV2Request(j)
+	jsonResp, jsonError := handleV2Request(j, reqLog)
 
 	if jsonError != nil {
 		handleV2Error(ctx, j, jsonError)
@@ -63,12 +94,66 @@ func handleV2(ctx *web.Context) {
 	ctx.Write([]byte(jsonResp.String()))
 }
 
-func handleV2Request(j *factom.JSON2Request) (*factom.JSON2Response, *factom.JSONError) {
+func handleV2Request(j *factom.JSON2Request, reqLog *Logger) (*factom.JSON2Response, *factom.JSONError) {
 	var resp interface{}
 	var jsonError *factom.JSONError
 	params := []byte(j.Params)
 
-	resp, jsonError = handleGenerateECAddress(params)
+	method := j.Method
+	if method == "" {
+		method = "change-price"
+	}
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		metricRequestSeconds.observe(duration.Seconds(), method)
+		if jsonError != nil {
+			metricErrors.inc(method, strconv.Itoa(jsonError.Code))
+			reqLog.Warn("Request failed", "method", method, "code", jsonError.Code, "error", jsonError.Error(), "duration_ms", duration.Seconds()*1000)
+			return
+		}
+		entryHash := ""
+		if h, ok := resp.(entryHasher); ok {
+			entryHash = h.entryHash()
+		}
+		reqLog.Info("Request", "method", method, "entry_hash", entryHash, "duration_ms", duration.Seconds()*1000)
+	}()
+
+	switch j.Method {
+	case "", "change-price":
+		resp, jsonError = handleGenerateECAddress(params)
+	case "propose-fer-change":
+		resp, jsonError = handleProposeFERChange(params)
+	case "approve-proposal", "reject-proposal":
+		resp, jsonError = handleVoteProposal(j.Method, params)
+	case "list-proposals":
+		resp, jsonError = handleListProposals(params)
+	case "prepare-fer-entry":
+		resp, jsonError = handlePrepareFEREntry(params)
+	case "finalize-fer-entry":
+		resp, jsonError = handleFinalizeFEREntry(params)
+	case "schedule-fer-change":
+		resp, jsonError = handleScheduleFERChange(params)
+	case "reschedule-fer-change":
+		resp, jsonError = handleRescheduleFERChange(params)
+	case "cancel-scheduled-change":
+		resp, jsonError = handleCancelScheduledChange(params)
+	case "list-scheduled-changes":
+		resp, jsonError = handleListScheduledChanges(params)
+	case "list-price-decisions":
+		resp, jsonError = handleListPriceDecisions(params)
+	case "get-fer-entry":
+		resp, jsonError = handleGetFEREntry(params)
+	case "list-local-fer-entries":
+		resp, jsonError = handleListLocalFEREntries(params)
+	case "get-rate-history":
+		resp, jsonError = handleGetRateHistory(params)
+	case "decode-commit":
+		resp, jsonError = handleDecodeCommit(params)
+	default:
+		method = "unknown"
+		jsonError = newMethodNotFoundError()
+	}
 
 	if jsonError != nil {
 		return nil, jsonError
@@ -77,7 +162,8 @@ func handleV2Request(j *factom.JSON2Request) (*factom.JSON2Response, *factom.JSO
 	jsonResp := factom.NewJSON2Response()
 	jsonResp.ID = j.ID
 	if b, err := json.Marshal(resp); err != nil {
-		return nil, newCustomInternalError(err.Error())
+		jsonError = newCustomInternalError(err.Error())
+		return nil, jsonError
 	} else {
 		jsonResp.Result = b
 	}
@@ -92,17 +178,39 @@ func handleGenerateECAddress(params []byte) (interface{}, *factom.JSONError) {
 		return nil, newInvalidRequestError()
 	}
 
-	entry, reveal, targetPriceInDollars, ecAddress, err := CreateFEREntryAndReveal(respParams.ExpirationHeight, respParams.ActivationHeight, respParams.Priority, respParams.NewPricePerEC)
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	// With approvals configured every change has to go through a proposal
+	if config.ApprovalsRequired > 0 {
+		publishPolicyRejection("change-price", "FER changes require approval")
+		return nil, newCustomInternalError("FER changes require approval, use propose-fer-change")
+	}
+
+	if err := checkFormat(respParams.Format); err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+	commitTime, err := commitTimeParam(respParams.CommitTimestamp)
+	if err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
+
+	entry, reveal, targetPriceInDollars, ecAddress, err := CreateFEREntryAndRevealAt(respParams.ExpirationHeight, respParams.ActivationHeight, respParams.Priority, respParams.NewPricePerEC, respParams.FERMetadata, commitTime)
 	if (err != nil) {
-		fmt.Println("Error: ", err)
 		return nil, newCustomInternalError(err.Error())
 	}
 
+	metricComposed.inc("change-price")
+
 	r := new(addressResponse)
 	r.EntryCommitJson = entry
 	r.RevealJson = reveal
 	r.TargetPriceInDollars = targetPriceInDollars
 	r.ECAddress = ecAddress
+	if err := r.applyFormat(respParams.Format, config.GetFactomdServer()); err != nil {
+		return nil, newCustomInternalError(err.Error())
+	}
 	resp := r
 
 	return resp, nil
@@ -113,20 +221,112 @@ type addressResponse struct {
 	RevealJson string `json:"reveal-entry"`
 	TargetPriceInDollars float64 `json:"target-price-in-dollars"`
 	ECAddress string `json:"ec-address"`
+	// Set for formats other than jsonrpc, see Formats.go
+	Format        string `json:"format,omitempty"`
+	Output        string `json:"output,omitempty"`
+	CommitMessage string `json:"commit-message,omitempty"`
+	RevealMessage string `json:"reveal-message,omitempty"`
 }
 type ChangeResponse struct {
 	ExpirationHeight string `json:"expiration-height"`
 	ActivationHeight string `json:"activation-height"`
 	Priority         string `json:"priority"`
 	NewPricePerEC    string `json:"new-price-per-EC"`
+	Format           string `json:"format"`
+	// Unix milliseconds for the commit, only with -deterministic
+	CommitTimestamp string `json:"commit-timestamp"`
+	// Only for FEREntry version 2.0
+	FERMetadata
+}
+
+// Commands run from the command line; without one the web server is started
+var commands = map[string]func(args []string) error{
+	"serve":         runServeCommand,
+	"compose":       runComposeCommand,
+	"sign":          runSignCommand,
+	"keystore":      runKeystoreCommand,
+	"backup":        runBackupCommand,
+	"restore":       runRestoreCommand,
+	"rate-history":  runRateHistoryCommand,
+	"verify":        runVerifyCommand,
+	"keygen":        runKeygenCommand,
+	"decode-commit": runDecodeCommitCommand,
+	"test-vectors":  runTestVectorsCommand,
 }
 
-// The main reads the config file, gets values from the command line for the FEREntry,
-// and then makes a curl commit and reveal string which it sends to a file.
+// The main runs the command named by the first argument, or "serve" when
+// the first argument is a flag or there is none.
 func main() {
-	port := *pflag
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	// Other commands print their output on stdout, so their logs go to stderr
+	if name != "serve" {
+		logOutput = os.Stderr
+	}
+	command, ok := commands[name]
+	if !ok {
+		logger.Error("Unknown command", "command", name, "commands", "serve, compose, sign, keystore, backup, restore, rate-history, verify, keygen, decode-commit, test-vectors")
+		os.Exit(2)
+	}
+	if err := command(args); err != nil {
+		logger.Error("Command failed", "command", name, "error", err)
+		os.Exit(1)
+	}
+}
+
+// "fer-api serve" starts the web server and the background loops.
+func runServeCommand(args []string) error {
+	flag.CommandLine.Parse(args)
+	configFileName = *confFlag
+	if err := setLogLevel(*logLevelFlag); err != nil {
+		return err
+	}
+
+	addr := *addrFlag
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", *pflag)
+	}
+
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		logger.Warn("Could not read config, using defaults", "error", err)
+	}
+	entryStore, err = OpenEntryStore(config.GetEntryStorePath())
+	if err != nil {
+		logger.Error("Could not open entry store", "error", err)
+		return err
+	}
+	defer entryStore.Close()
+	if err := loadSubmittedFEREntries(entryStore); err != nil {
+		logger.Warn("Could not load submitted entries for metrics", "error", err)
+	}
 
 	webServer = web.NewServer()
+	webServer.Logger = log.New(logWriter{logger.With("component", "web"), LevelDebug}, "", 0)
 	webServer.Post("/change-price", handleV2)
-	webServer.Run(fmt.Sprintf(":%d", port))
+	webServer.Post("/v2", handleV2)
+	webServer.Get("/healthz", handleHealthz)
+	webServer.Get("/readyz", handleReadyz)
+	webServer.Get("/metrics", handleMetrics)
+	webServer.Get("/events", handleEvents)
+
+	go collectMetrics(*metricsIntervalFlag)
+	go runScheduler(*scheduleIntervalFlag)
+	go runPriceFeed()
+	go runTopUp()
+	go runLifecycleTracker(*trackIntervalFlag)
+	go runWebhookDelivery(time.Second)
+	go runChainWatcher(*watchIntervalFlag)
+
+	err = runServer(addr)
+	// Let a scheduler, price feed or top-up pass that is submitting finish first
+	schedulerPassLock.Lock()
+	priceFeedLock.Lock()
+	topUpLock.Lock()
+	if err != nil {
+		logger.Error("Server stopped", "error", err)
+	}
+	return err
 }